@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"fmt"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -34,7 +36,20 @@ func (d base) parseBool(value reflect.Value) bool {
 	return value.Bool()
 }
 
-func (d base) setPtrValue(driverValue, fieldValue reflect.Value) {
+// Aggregate result of integer column may be integer or decimal bytes.
+func (d base) parseFloat(value reflect.Value) (float64, error) {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), nil
+	case reflect.Slice:
+		return strconv.ParseFloat(string(value.Bytes()), 64)
+	case reflect.String:
+		return strconv.ParseFloat(value.String(), 64)
+	}
+	return value.Float(), nil
+}
+
+func (d base) setPtrValue(driverValue, fieldValue reflect.Value) error {
 	t := fieldValue.Type().Elem()
 	v := reflect.New(t)
 	fieldValue.Set(v)
@@ -47,14 +62,27 @@ func (d base) setPtrValue(driverValue, fieldValue reflect.Value) {
 		v.Elem().SetFloat(driverValue.Interface().(float64))
 	case reflect.Bool:
 		v.Elem().SetBool(driverValue.Interface().(bool))
+	case reflect.Struct:
+		// *time.Time of the deleted field
+		return d.dialect.setModelValue(driverValue, v.Elem())
 	}
+	return nil
 }
 func (d base) setModelValue(driverValue, fieldValue reflect.Value) error {
 	switch fieldValue.Type().Kind() {
 	case reflect.Bool:
 		fieldValue.SetBool(d.dialect.parseBool(driverValue.Elem()))
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		fieldValue.SetInt(driverValue.Elem().Int())
+		if driverValue.Elem().Kind() == reflect.Slice {
+			// decimal value like SUM result is returned as bytes.
+			i, err := strconv.ParseInt(string(driverValue.Elem().Bytes()), 10, 64)
+			if err != nil {
+				return err
+			}
+			fieldValue.SetInt(i)
+		} else {
+			fieldValue.SetInt(driverValue.Elem().Int())
+		}
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
 		// reading uint from int value causes panic
 		switch driverValue.Elem().Kind() {
@@ -64,7 +92,11 @@ func (d base) setModelValue(driverValue, fieldValue reflect.Value) error {
 			fieldValue.SetUint(driverValue.Elem().Uint())
 		}
 	case reflect.Float32, reflect.Float64:
-		fieldValue.SetFloat(driverValue.Elem().Float())
+		f, err := d.parseFloat(driverValue.Elem())
+		if err != nil {
+			return err
+		}
+		fieldValue.SetFloat(f)
 	case reflect.String:
 		fieldValue.SetString(string(driverValue.Elem().Bytes()))
 	case reflect.Slice:
@@ -72,7 +104,7 @@ func (d base) setModelValue(driverValue, fieldValue reflect.Value) error {
 			fieldValue.SetBytes(driverValue.Elem().Bytes())
 		}
 	case reflect.Ptr:
-		d.setPtrValue(driverValue, fieldValue)
+		return d.setPtrValue(driverValue, fieldValue)
 	case reflect.Struct:
 		switch fieldValue.Interface().(type) {
 		case time.Time:
@@ -88,6 +120,11 @@ func (d base) setModelValue(driverValue, fieldValue reflect.Value) error {
 }
 
 func (d base) querySql(criteria *criteria) (string, []interface{}) {
+	query, args := d.dialect.selectSql(criteria)
+	return d.dialect.substituteMarkers(query), args
+}
+
+func (d base) selectSql(criteria *criteria) (string, []interface{}) {
 	query := new(bytes.Buffer)
 	args := make([]interface{}, 0, 20)
 	table := d.dialect.quote(criteria.model.table)
@@ -95,36 +132,46 @@ func (d base) querySql(criteria *criteria) (string, []interface{}) {
 	tables := []string{table}
 	hasJoin := len(criteria.model.refs) > 0
 	for _, v := range criteria.model.fields {
+		if !criteria.selects(v.camelName) {
+			continue
+		}
 		colName := d.dialect.quote(v.name)
 		if hasJoin {
 			colName = d.dialect.quote(criteria.model.table) + "." + colName
 		}
 		columns = append(columns, colName)
 	}
-	for k, v := range criteria.model.refs {
-		tableAlias := StructNameToTableName(k)
-		quotedTableAlias := d.dialect.quote(tableAlias)
-		quotedParentTable := d.dialect.quote(v.model.table)
-		leftKey := table + "." + d.dialect.quote(v.refKey)
-		parentPrimary := quotedTableAlias + "." + d.dialect.quote(v.model.pk.name)
-		joinClause := fmt.Sprintf("LEFT JOIN %v AS %v ON %v = %v", quotedParentTable, quotedTableAlias, leftKey, parentPrimary)
-		tables = append(tables, joinClause)
-		for _, f := range v.model.fields {
-			alias := tableAlias + "___" + f.name
-			columns = append(columns, d.dialect.quote(tableAlias+"."+f.name)+" AS "+alias)
+	for _, v := range criteria.model.exprFields {
+		if !criteria.selects(v.camelName) {
+			continue
 		}
+		columns = append(columns, v.expr+" AS "+d.dialect.quote(v.name))
 	}
+	tables, columns = d.selectRefs(criteria, table, "", "", criteria.model.refs, tables, columns)
 	query.WriteString("SELECT ")
+	if criteria.distinct {
+		query.WriteString("DISTINCT ")
+	}
 	query.WriteString(strings.Join(columns, ", "))
 	query.WriteString(" FROM ")
 	query.WriteString(strings.Join(tables, " "))
 
-	if criteria.condition != nil {
-		cexpr, cargs := criteria.condition.Merge()
+	if condition := criteria.scopedCondition(d.dialect); condition != nil {
+		cexpr, cargs := condition.merge(d.dialect)
 		query.WriteString(" WHERE ")
 		query.WriteString(cexpr)
 		args = append(args, cargs...)
 	}
+	if len(criteria.groupBys) > 0 {
+		query.WriteString(" GROUP BY ")
+		query.WriteString(strings.Join(criteria.groupBys, ", "))
+	}
+	if criteria.having != nil {
+		hexpr, hargs := criteria.having.merge(d.dialect)
+		query.WriteString(" HAVING ")
+		query.WriteString(hexpr)
+		args = append(args, hargs...)
+	}
 	orderByLen := len(criteria.orderBys)
 	if orderByLen > 0 {
 		query.WriteString(" ORDER BY ")
@@ -147,7 +194,86 @@ func (d base) querySql(criteria *criteria) (string, []interface{}) {
 		query.WriteString(" OFFSET ?")
 		args = append(args, criteria.offset)
 	}
-	return d.dialect.substituteMarkers(query.String()), args
+	return query.String(), args
+}
+
+// selectRefs appends the join clauses and the columns of the referenced structs recursively,
+// the alias of a nested reference is prefixed by the alias of its parent, like post___author,
+// so its columns are aliased like post___author___name.
+func (d base) selectRefs(criteria *criteria, quotedTable, path, aliasPrefix string, refs map[string]*reference,
+	tables, columns []string) ([]string, []string) {
+	for _, k := range refNames(refs) {
+		v := refs[k]
+		refPath := path + k
+		if !criteria.selectsRef(refPath) {
+			continue
+		}
+		tableAlias := aliasPrefix + StructNameToTableName(k)
+		tables = append(tables, d.joinClause(quotedTable, tableAlias, v))
+		for _, f := range v.model.fields {
+			if !criteria.selects(refPath + "." + f.camelName) {
+				continue
+			}
+			alias := tableAlias + "___" + f.name
+			columns = append(columns, d.dialect.quote(tableAlias+"."+f.name)+" AS "+alias)
+		}
+		tables, columns = d.selectRefs(criteria, d.dialect.quote(tableAlias), refPath+".", tableAlias+"___",
+			v.model.refs, tables, columns)
+	}
+	return tables, columns
+}
+
+// joinRefs appends the join clauses of the referenced structs recursively, the same as selectRefs.
+func (d base) joinRefs(quotedTable, aliasPrefix string, refs map[string]*reference, tables []string) []string {
+	for _, k := range refNames(refs) {
+		tableAlias := aliasPrefix + StructNameToTableName(k)
+		tables = append(tables, d.joinClause(quotedTable, tableAlias, refs[k]))
+		tables = d.joinRefs(d.dialect.quote(tableAlias), tableAlias+"___", refs[k].model.refs, tables)
+	}
+	return tables
+}
+
+// refNames returns the sorted names of the references, so the joins are in the same order.
+func refNames(refs map[string]*reference) []string {
+	names := make([]string, 0, len(refs))
+	for k := range refs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (d base) joinClause(quotedTable, tableAlias string, ref *reference) string {
+	quotedTableAlias := d.dialect.quote(tableAlias)
+	quotedParentTable := d.dialect.quote(ref.model.table)
+	leftKey := quotedTable + "." + d.dialect.quote(ref.refKey)
+	parentPrimary := quotedTableAlias + "." + d.dialect.quote(ref.model.pk.name)
+	return fmt.Sprintf("LEFT JOIN %v AS %v ON %v = %v", quotedParentTable, quotedTableAlias, leftKey, parentPrimary)
+}
+
+func (d base) aggregateSql(criteria *criteria, expr string) (string, []interface{}) {
+	table := d.dialect.quote(criteria.model.table)
+	tables := d.joinRefs(table, "", criteria.model.refs, []string{table})
+	query := "SELECT " + expr + " FROM " + strings.Join(tables, " ")
+	var args []interface{}
+	if condition := criteria.scopedCondition(d.dialect); condition != nil {
+		cexpr, cargs := condition.merge(d.dialect)
+		query += " WHERE " + cexpr
+		args = cargs
+	}
+	return d.dialect.substituteMarkers(query), args
+}
+
+func (d base) placeholderLimit() int {
+	return 65535
+}
+
+func (d base) maxInValues() int {
+	return 0
+}
+
+func (d base) iLikeSql(column string) string {
+	return "LOWER(" + column + ") LIKE LOWER(?)"
 }
 
 func (d base) insert(q *Qbs) (int64, error) {
@@ -180,6 +306,125 @@ func (d base) insertSql(criteria *criteria) (string, []interface{}) {
 	return sql, values
 }
 
+// The ids generated by a multi-row insert statement are consecutive from the last insert id in MySQL.
+func (d base) bulkInsert(q *Qbs, models []*model) ([]int64, error) {
+	sql, args := d.dialect.bulkInsertSql(models)
+	result, err := q.Exec(sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return consecutiveIds(id, len(models)), nil
+}
+
+func consecutiveIds(first int64, n int) []int64 {
+	ids := make([]int64, n)
+	for i := range ids {
+		ids[i] = first + int64(i)
+	}
+	return ids
+}
+
+func (d base) bulkInsertSql(models []*model) (string, []interface{}) {
+	columns, _ := models[0].columnsAndValues(false)
+	quotedColumns := make([]string, 0, len(columns))
+	markers := make([]string, 0, len(columns))
+	for _, c := range columns {
+		quotedColumns = append(quotedColumns, d.dialect.quote(c))
+		markers = append(markers, "?")
+	}
+	row := "(" + strings.Join(markers, ", ") + ")"
+	rows := make([]string, 0, len(models))
+	args := make([]interface{}, 0, len(columns)*len(models))
+	for _, m := range models {
+		_, values := m.columnsAndValues(false)
+		rows = append(rows, row)
+		args = append(args, values...)
+	}
+	sql := fmt.Sprintf(
+		"INSERT INTO %v (%v) VALUES %v",
+		d.dialect.quote(models[0].table),
+		strings.Join(quotedColumns, ", "),
+		strings.Join(rows, ", "),
+	)
+	return sql, args
+}
+
+func (d base) upsert(q *Qbs, conflictColumns []string) (int64, error) {
+	sql, args := d.dialect.upsertSql(q.criteria, conflictColumns)
+	result, err := q.Exec(sql, args...)
+	if err != nil {
+		return -1, err
+	}
+	return result.LastInsertId()
+}
+
+func (d base) upsertSql(criteria *criteria, conflictColumns []string) (string, []interface{}) {
+	sql, values := d.insertSql(criteria)
+	quotedConflicts := make([]string, 0, len(conflictColumns))
+	for _, c := range conflictColumns {
+		quotedConflicts = append(quotedConflicts, d.dialect.quote(c))
+	}
+	pairs := []string{}
+	for _, c := range upsertUpdateColumns(criteria.model, conflictColumns) {
+		pairs = append(pairs, d.dialect.quote(c)+" = EXCLUDED."+d.dialect.quote(c))
+	}
+	if len(pairs) == 0 {
+		pairs = append(pairs, quotedConflicts[0]+" = EXCLUDED."+quotedConflicts[0])
+	}
+	sql += " ON CONFLICT (" + strings.Join(quotedConflicts, ", ") + ") DO UPDATE SET " + strings.Join(pairs, ", ")
+	return sql, values
+}
+
+// upsertUpdateColumns returns the inserted columns which should be updated on conflict,
+// the primary key, the conflict columns and the created time column are not updated.
+func upsertUpdateColumns(model *model, conflictColumns []string) []string {
+	columns, _ := model.columnsAndValues(false)
+	var created string
+	if f := model.timeField("created"); f != nil {
+		created = f.name
+	}
+	updates := make([]string, 0, len(columns))
+	for _, c := range columns {
+		if c == model.pk.name || c == created || containsString(conflictColumns, c) {
+			continue
+		}
+		updates = append(updates, c)
+	}
+	return updates
+}
+
+// returningId performs the insert statement which returns the primary key.
+func returningId(q *Qbs, query string, args []interface{}) (int64, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return -1, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return -1, err
+		}
+		return -1, sql.ErrNoRows
+	}
+	var id interface{}
+	err = rows.Scan(&id)
+	intId, _ := id.(int64)
+	return intId, err
+}
+
+func containsString(strs []string, str string) bool {
+	for _, v := range strs {
+		if v == str {
+			return true
+		}
+	}
+	return false
+}
+
 func (d base) update(q *Qbs) (int64, error) {
 	sql, args := d.dialect.updateSql(q.criteria)
 	result, err := q.Exec(sql, args...)
@@ -196,7 +441,31 @@ func (d base) updateSql(criteria *criteria) (string, []interface{}) {
 	for _, column := range columns {
 		pairs = append(pairs, fmt.Sprintf("%v = ?", d.dialect.quote(column)))
 	}
-	conditionSql, args := criteria.condition.Merge()
+	conditionSql, args := criteria.condition.merge(d.dialect)
+	sql := fmt.Sprintf(
+		"UPDATE %v SET %v WHERE %v",
+		d.dialect.quote(criteria.model.table),
+		strings.Join(pairs, ", "),
+		conditionSql,
+	)
+	values = append(values, args...)
+	return sql, values
+}
+
+// The columns are sorted, so the same columns make the same statement.
+func (d base) updateExprSql(criteria *criteria, exprs map[string]Expr) (string, []interface{}) {
+	columns := make([]string, 0, len(exprs))
+	for column := range exprs {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	pairs := make([]string, 0, len(columns))
+	values := make([]interface{}, 0, len(columns))
+	for _, column := range columns {
+		pairs = append(pairs, d.dialect.quote(column)+" = "+exprs[column].expr)
+		values = append(values, exprs[column].args...)
+	}
+	conditionSql, args := criteria.condition.merge(d.dialect)
 	sql := fmt.Sprintf(
 		"UPDATE %v SET %v WHERE %v",
 		d.dialect.quote(criteria.model.table),
@@ -217,7 +486,7 @@ func (d base) delete(q *Qbs) (int64, error) {
 }
 
 func (d base) deleteSql(criteria *criteria) (string, []interface{}) {
-	conditionSql, args := criteria.condition.Merge()
+	conditionSql, args := criteria.condition.merge(d.dialect)
 	sql := "DELETE FROM " + d.dialect.quote(criteria.model.table) + " WHERE " + conditionSql
 	return sql, args
 }
@@ -249,8 +518,8 @@ func (d base) createTableSql(model *model, ifNotExists bool) string {
 			a = append(a, ", ")
 		}
 	}
-	for _, v := range model.refs {
-		if v.foreignKey {
+	for _, k := range refNames(model.refs) {
+		if v := model.refs[k]; v.foreignKey {
 			a = append(a, ", FOREIGN KEY (", d.dialect.quote(v.refKey), ") REFERENCES ")
 			a = append(a, d.dialect.quote(v.model.table), " (", d.dialect.quote(v.model.pk.name), ") ON DELETE CASCADE")
 		}
@@ -315,3 +584,27 @@ func (d base) columnsInTable(mg *Migration, table interface{}) map[string]bool {
 func (d base) catchMigrationError(err error) bool {
 	return false
 }
+
+func (d base) savepointSql(name string) string {
+	return "SAVEPOINT " + name
+}
+
+func (d base) releaseSavepointSql(name string) string {
+	return "RELEASE SAVEPOINT " + name
+}
+
+func (d base) rollbackToSavepointSql(name string) string {
+	return "ROLLBACK TO SAVEPOINT " + name
+}
+
+func (d base) isRetryableError(err error) bool {
+	return false
+}
+
+func (d base) isDuplicateKeyError(err error) bool {
+	return false
+}
+
+func (d base) txOptions(opts sql.TxOptions) sql.TxOptions {
+	return opts
+}
@@ -75,7 +75,7 @@ func doBenchmarkStmtQuery(b benchmarker, n int) {
 	q.Save(bas)
 	closeMigrationAndQbs(mg, q)
 	b.StartTimer()
-	db, _ := sql.Open(driver, driverSource)
+	db, _ := sql.Open(defaultDB.driver, defaultDB.driverSource)
 	query := q.Dialect.substituteMarkers("SELECT * FROM basic WHERE id = ?")
 	stmt, _ := db.Prepare(query)
 	for i := 0; i < n; i++ {
@@ -112,7 +112,7 @@ func doBenchmarkDbQuery(b benchmarker, n int) {
 	q.Save(bas)
 	closeMigrationAndQbs(mg, q)
 	b.StartTimer()
-	db, _ := sql.Open(driver, driverSource)
+	db, _ := sql.Open(defaultDB.driver, defaultDB.driverSource)
 	query := q.Dialect.substituteMarkers("SELECT * FROM basic WHERE id = ?")
 	for i := 0; i < n; i++ {
 		ba := new(basic)
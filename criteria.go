@@ -1,13 +1,75 @@
 package qbs
 
+import (
+	"reflect"
+	"strings"
+)
+
 type criteria struct {
-	model      *model
-	condition  *Condition
-	orderBys   []order
-	limit      int
-	offset     int
-	omitFields []string
-	omitJoin   bool
+	model        *model
+	condition    *Condition
+	orderBys     []order
+	groupBys     []string
+	having       *Condition
+	limit        int
+	offset       int
+	omitFields   []string
+	omitJoin     bool
+	joinDepth    int
+	selectFields []string
+	distinct     bool
+	deletedScope int
+	preloads     []string
+}
+
+// The scopes of soft deleted rows in queries.
+const (
+	excludeDeleted = iota
+	withDeleted
+	onlyDeleted
+)
+
+// scopedCondition appends the condition of the deleted scope to the condition if the model has a deleted field.
+func (c *criteria) scopedCondition(d Dialect) *Condition {
+	if c.model == nil || c.model.deleted == nil || c.deletedScope == withDeleted {
+		return c.condition
+	}
+	column := d.quote(c.model.table) + "." + d.quote(c.model.deleted.name)
+	expr := column + " IS NULL"
+	if c.deletedScope == onlyDeleted {
+		expr = column + " IS NOT NULL"
+	}
+	if c.condition == nil {
+		return NewCondition(expr)
+	}
+	return (&Condition{left: c.condition}).AndCondition(NewCondition(expr))
+}
+
+// selects reports whether the field path like "Name" or "Author.Name" is selected,
+// every field is selected if no field is selected explicitly.
+func (c *criteria) selects(path string) bool {
+	if len(c.selectFields) == 0 {
+		return true
+	}
+	for _, v := range c.selectFields {
+		if v == path || strings.HasPrefix(path, v+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// selectsRef reports whether any field of the referenced struct is selected.
+func (c *criteria) selectsRef(refName string) bool {
+	if len(c.selectFields) == 0 {
+		return true
+	}
+	for _, v := range c.selectFields {
+		if v == refName || strings.HasPrefix(v, refName+".") {
+			return true
+		}
+	}
+	return false
 }
 
 func (c *criteria) mergePkCondition(d Dialect) {
@@ -22,6 +84,59 @@ func (c *criteria) mergePkCondition(d Dialect) {
 	c.condition = con
 }
 
+// inChunks splits the values of the top level in condition into chunks which stay under the placeholder limit,
+// it returns nil if the condition doesn't need to be split, or the split changes the result,
+// like limit, order, grouping, distinct and select expressions which may aggregate rows across chunks.
+func (c *criteria) inChunks(d Dialect) [][]interface{} {
+	con := c.condition
+	if con == nil || con.inOp != " IN " || con.sub != nil || con.left != nil || con.not {
+		return nil
+	}
+	if c.limit > 0 || c.offset > 0 || len(c.orderBys) > 0 {
+		return nil
+	}
+	if len(c.groupBys) > 0 || c.distinct || c.having != nil || c.model != nil && len(c.model.exprFields) > 0 {
+		return nil
+	}
+	size := d.placeholderLimit()
+	if len(con.args) <= size {
+		return nil
+	}
+	// a row matching a value repeated in different chunks would be returned more than once.
+	values := uniqueValues(con.args)
+	var chunks [][]interface{}
+	for i := 0; i < len(values); i += size {
+		end := i + size
+		if end > len(values) {
+			end = len(values)
+		}
+		chunks = append(chunks, values[i:end])
+	}
+	return chunks
+}
+
+// uniqueValues removes the repeated values, the values are compared like the keys of Preload,
+// so int and int64 values of the same number are the same.
+func uniqueValues(values []interface{}) []interface{} {
+	seen := make(map[interface{}]bool, len(values))
+	unique := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		if v == nil || !reflect.TypeOf(v).Comparable() {
+			unique = append(unique, v)
+			continue
+		}
+		key := preloadKey(reflect.ValueOf(v))
+		if key != nil && reflect.TypeOf(key).Comparable() {
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+		}
+		unique = append(unique, v)
+	}
+	return unique
+}
+
 type order struct {
 	path string
 	desc bool
@@ -30,10 +145,16 @@ type order struct {
 // Conditions are structured in a way to define
 // complex where clause easily.
 type Condition struct {
-	expr string
-	args []interface{}
-	sub  *Condition
-	isOr bool
+	expr   string
+	args   []interface{}
+	column string     // if set, expr follows the column which will be quoted by the dialect.
+	raw    bool       // column is not quoted, for conditions created by NewInCondition.
+	inOp   string     // " IN " or " NOT IN ", the args are the values.
+	ilike  bool       // case insensitive like on column, rendered by the dialect.
+	left   *Condition // merged before sub, set when another condition is appended.
+	not    bool
+	sub    *Condition
+	isOr   bool
 }
 
 func NewCondition(expr string, args ...interface{}) *Condition {
@@ -49,23 +170,194 @@ func NewEqualCondition(column string, value interface{}) *Condition {
 	return NewCondition(expr, value)
 }
 
-func NewInCondition(column string, values []interface{}) *Condition {
-	expr := column + " IN ("
-	for _ = range values {
-		expr += "?, "
+// The values can be a slice of any type, an empty slice or nil makes an always false condition.
+func NewInCondition(column string, values interface{}) *Condition {
+	return &Condition{
+		column: column,
+		raw:    true,
+		inOp:   " IN ",
+		args:   toInterfaces(values),
 	}
-	expr = expr[:len(expr)-2]
-	expr += ")"
+}
+
+func toInterfaces(values interface{}) []interface{} {
+	if values == nil {
+		return nil
+	}
+	if ifaces, ok := values.([]interface{}); ok {
+		return ifaces
+	}
+	sliceValue := reflect.ValueOf(values)
+	if sliceValue.Kind() != reflect.Slice && sliceValue.Kind() != reflect.Array {
+		panic("values of in condition should be a slice")
+	}
+	ifaces := make([]interface{}, sliceValue.Len())
+	for i := 0; i < len(ifaces); i++ {
+		ifaces[i] = sliceValue.Index(i).Interface()
+	}
+	return ifaces
+}
+
+// inSql splits values into groups of at most max values if max is positive,
+// as some databases limit the number of values in a list.
+func inSql(column, op string, n, max int) string {
+	if n == 0 {
+		if op == " IN " {
+			return "1 = 0"
+		}
+		return "1 = 1"
+	}
+	if max <= 0 || n <= max {
+		return column + inExpr(op, n)
+	}
+	groups := make([]string, 0, n/max+1)
+	for i := 0; i < n; i += max {
+		size := max
+		if n-i < max {
+			size = n - i
+		}
+		groups = append(groups, column+inExpr(op, size))
+	}
+	if op == " IN " {
+		return "(" + strings.Join(groups, " OR ") + ")"
+	}
+	return "(" + strings.Join(groups, " AND ") + ")"
+}
+
+func inExpr(op string, n int) string {
+	expr := op + "("
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			expr += ", "
+		}
+		expr += "?"
+	}
+	return expr + ")"
+}
+
+func newColumnCondition(column, expr string, args ...interface{}) *Condition {
 	return &Condition{
-		expr: expr,
-		args: values,
+		column: column,
+		expr:   expr,
+		args:   args,
 	}
 }
 
-func (c *Condition) And(expr string, args ...interface{}) *Condition {
+// Snakecase column name, the column will be quoted by the dialect, the same as the following typed conditions.
+func Eq(column string, value interface{}) *Condition {
+	return newColumnCondition(column, " = ?", value)
+}
+
+func Ne(column string, value interface{}) *Condition {
+	return newColumnCondition(column, " <> ?", value)
+}
+
+func Gt(column string, value interface{}) *Condition {
+	return newColumnCondition(column, " > ?", value)
+}
+
+func Gte(column string, value interface{}) *Condition {
+	return newColumnCondition(column, " >= ?", value)
+}
+
+func Lt(column string, value interface{}) *Condition {
+	return newColumnCondition(column, " < ?", value)
+}
+
+func Lte(column string, value interface{}) *Condition {
+	return newColumnCondition(column, " <= ?", value)
+}
+
+func Like(column string, pattern string) *Condition {
+	return newColumnCondition(column, " LIKE ?", pattern)
+}
+
+// Case insensitive like, it's ILIKE in postgres, and LOWER(column) LIKE LOWER(pattern) in other databases.
+func ILike(column string, pattern string) *Condition {
+	c := newColumnCondition(column, "", pattern)
+	c.ilike = true
+	return c
+}
+
+func Between(column string, from, to interface{}) *Condition {
+	return newColumnCondition(column, " BETWEEN ? AND ?", from, to)
+}
+
+func IsNull(column string) *Condition {
+	return newColumnCondition(column, " IS NULL")
+}
+
+func IsNotNull(column string) *Condition {
+	return newColumnCondition(column, " IS NOT NULL")
+}
+
+// The values can be a slice of any type, an empty slice or nil makes an always true condition.
+func NotIn(column string, values interface{}) *Condition {
+	c := newColumnCondition(column, "", toInterfaces(values)...)
+	c.inOp = " NOT IN "
+	return c
+}
+
+// Not negates the condition.
+func Not(condition *Condition) *Condition {
+	return &Condition{
+		left: condition,
+		not:  true,
+	}
+}
+
+// Expr is a SQL expression with arguments which a column is updated to by UpdateExpr.
+type Expr struct {
+	expr string
+	args []interface{}
+}
+
+// Raw creates an expression like Raw("view_count + ?", 1).
+func Raw(expr string, args ...interface{}) Expr {
+	return Expr{expr, args}
+}
+
+// Subquery is a select statement built by Qbs.Subquery, it can be embedded in conditions,
+// its arguments are merged in order and its markers are substituted along with the outer statement.
+type Subquery struct {
+	sql  string
+	args []interface{}
+}
+
+// Snakecase column name, the column will be quoted by the dialect.
+// The subquery should select exactly one column.
+func InSubquery(column string, sub *Subquery) *Condition {
+	return newColumnCondition(column, " IN ("+sub.sql+")", sub.args...)
+}
+
+func NotInSubquery(column string, sub *Subquery) *Condition {
+	return newColumnCondition(column, " NOT IN ("+sub.sql+")", sub.args...)
+}
+
+func Exists(sub *Subquery) *Condition {
+	return NewCondition("EXISTS ("+sub.sql+")", sub.args...)
+}
+
+func NotExists(sub *Subquery) *Condition {
+	return NewCondition("NOT EXISTS ("+sub.sql+")", sub.args...)
+}
+
+// CompareSubquery compares the column with a scalar subquery by op like "=", "<>", ">" or "<=",
+// the subquery should select exactly one column and return at most one row.
+func CompareSubquery(column, op string, sub *Subquery) *Condition {
+	return newColumnCondition(column, " "+op+" ("+sub.sql+")", sub.args...)
+}
+
+// nest moves the current condition into left, so a new sub condition can be appended.
+func (c *Condition) nest() {
 	if c.sub != nil {
-		c.expr, c.args = c.Merge()
+		left := *c
+		*c = Condition{left: &left}
 	}
+}
+
+func (c *Condition) And(expr string, args ...interface{}) *Condition {
+	c.nest()
 	c.sub = NewCondition(expr, args...)
 	c.isOr = false
 	return c
@@ -79,18 +371,14 @@ func (c *Condition) AndEqual(column string, value interface{}) *Condition {
 }
 
 func (c *Condition) AndCondition(subCondition *Condition) *Condition {
-	if c.sub != nil {
-		c.expr, c.args = c.Merge()
-	}
+	c.nest()
 	c.sub = subCondition
 	c.isOr = false
 	return c
 }
 
 func (c *Condition) Or(expr string, args ...interface{}) *Condition {
-	if c.sub != nil {
-		c.expr, c.args = c.Merge()
-	}
+	c.nest()
 	c.sub = NewCondition(expr, args...)
 	c.isOr = true
 	return c
@@ -104,17 +392,50 @@ func (c *Condition) OrEqual(column string, value interface{}) *Condition {
 }
 
 func (c *Condition) OrCondition(subCondition *Condition) *Condition {
-	if c.sub != nil {
-		c.expr, c.args = c.Merge()
-	}
+	c.nest()
 	c.sub = subCondition
 	c.isOr = true
 	return c
 }
 
+// Merge the condition into a SQL expression, the typed condition's column is not quoted.
 func (c *Condition) Merge() (expr string, args []interface{}) {
-	expr = c.expr
-	args = c.args
+	return c.merge(nil)
+}
+
+// merge quotes the typed condition's column with dialect d if d is not nil.
+func (c *Condition) merge(d Dialect) (expr string, args []interface{}) {
+	switch {
+	case c.left != nil:
+		expr, args = c.left.merge(d)
+	case c.column != "":
+		column := c.column
+		if d != nil && !c.raw {
+			column = d.quote(column)
+		}
+		if c.inOp != "" {
+			max := 0
+			if d != nil {
+				max = d.maxInValues()
+			}
+			expr = inSql(column, c.inOp, len(c.args), max)
+		} else if c.ilike {
+			if d != nil {
+				expr = d.iLikeSql(column)
+			} else {
+				expr = base{}.iLikeSql(column)
+			}
+		} else {
+			expr = column + c.expr
+		}
+		args = append(args, c.args...)
+	default:
+		expr = c.expr
+		args = append(args, c.args...)
+	}
+	if c.not {
+		expr = "NOT (" + expr + ")"
+	}
 	if c.sub == nil {
 		return
 	}
@@ -124,7 +445,7 @@ func (c *Condition) Merge() (expr string, args []interface{}) {
 	} else {
 		expr += " AND "
 	}
-	subExpr, subArgs := c.sub.Merge()
+	subExpr, subArgs := c.sub.merge(d)
 	expr += "(" + subExpr + ")"
 	args = append(args, subArgs...)
 	return expr, args
@@ -0,0 +1,73 @@
+package qbs
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInCondition(t *testing.T) {
+	assert := NewAssert(t)
+	expr, args := NewInCondition("id", []int64{1, 2}).Merge()
+	assert.Equal("id IN (?, ?)", expr)
+	assert.Equal(2, len(args))
+	expr, args = NewInCondition("id", []string{}).Merge()
+	assert.Equal("1 = 0", expr)
+	assert.Equal(0, len(args))
+	expr, _ = NotIn("id", nil).Merge()
+	assert.Equal("1 = 1", expr)
+	expr, _ = NotIn("id", [2]int{3, 4}).merge(NewMysql())
+	assert.Equal("`id` NOT IN (?, ?)", expr)
+}
+
+func TestInConditionSplit(t *testing.T) {
+	assert := NewAssert(t)
+	values := make([]int, 2500)
+	expr, args := NewInCondition("id", values).merge(NewOracle())
+	assert.Equal(2500, len(args))
+	assert.Equal(2, strings.Count(expr, " OR "))
+	assert.True(strings.HasPrefix(expr, "(id IN (?, "))
+}
+
+func TestInChunks(t *testing.T) {
+	assert := NewAssert(t)
+	ids := make([]int64, 2000)
+	for i := range ids {
+		ids[i] = int64(i)
+	}
+	c := &criteria{condition: NewInCondition("id", ids)}
+	chunks := c.inChunks(NewSqlite3())
+	assert.MustEqual(3, len(chunks))
+	assert.Equal(999, len(chunks[0]))
+	assert.Equal(2, len(chunks[2]))
+	assert.Nil(c.inChunks(NewMysql()))
+	c.limit = 10
+	assert.Nil(c.inChunks(NewSqlite3()))
+	c.limit = 0
+	c.groupBys = []string{"state"}
+	assert.Nil(c.inChunks(NewSqlite3()))
+	c.groupBys = nil
+	c.distinct = true
+	assert.Nil(c.inChunks(NewSqlite3()))
+	c.distinct = false
+	c.having = NewCondition("COUNT(*) > ?", 1)
+	assert.Nil(c.inChunks(NewSqlite3()))
+	c.having = nil
+	c.model = &model{exprFields: []*modelField{{name: "total", expr: "COUNT(*)"}}}
+	assert.Nil(c.inChunks(NewSqlite3()))
+	c.model = &model{}
+	assert.Equal(3, len(c.inChunks(NewSqlite3())))
+}
+
+func TestInChunksUnique(t *testing.T) {
+	assert := NewAssert(t)
+	values := make([]interface{}, 0, 2000)
+	for i := 0; i < 1000; i++ {
+		values = append(values, int64(i), i)
+	}
+	c := &criteria{condition: NewInCondition("id", values)}
+	chunks := c.inChunks(NewSqlite3())
+	assert.MustEqual(2, len(chunks))
+	assert.Equal(999, len(chunks[0]))
+	assert.Equal(1, len(chunks[1]))
+	assert.Equal(999, chunks[1][0])
+}
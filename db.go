@@ -0,0 +1,221 @@
+package qbs
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// DB represents a registered database, it owns the connection pool, the dialect,
+// the prepared statement cache, the connection limit and the loggers.
+// The package level functions like Register and GetQbs work with a default DB,
+// call Open or NewDB to work with more than one database in the same application.
+type DB struct {
+	driver          string
+	driverSource    string
+	dbName          string
+	dialect         Dialect
+	sqlDb           *sql.DB
+	stmtMap         map[string]*sql.Stmt
+	mu              *sync.RWMutex
+	connectionLimit chan struct{}
+	blockingOnLimit bool
+	queryLogger     *log.Logger
+	errorLogger     *log.Logger
+	retryBackoff    func(retry int) time.Duration
+	dryRun          bool
+	closed          bool // guarded by mu, the *sql.DB is kept after Close so it reports it's closed.
+}
+
+var defaultDB = newDB()
+
+func newDB() *DB {
+	return &DB{
+		stmtMap:     make(map[string]*sql.Stmt),
+		mu:          new(sync.RWMutex),
+		queryLogger: log.New(os.Stdout, "qbs:", log.LstdFlags),
+		errorLogger: log.New(os.Stderr, "qbs:", log.LstdFlags),
+	}
+}
+
+// Open a database which is independent of the default registered database.
+func Open(driverName, driverSourceName, databaseName string, dialect Dialect) (*DB, error) {
+	database, err := sql.Open(driverName, driverSourceName)
+	if err != nil {
+		return nil, err
+	}
+	d := NewDB(driverName, database, dialect)
+	d.driverSource = driverSourceName
+	d.dbName = databaseName
+	return d, nil
+}
+
+// Open a database with data source name which is independent of the default registered database.
+func OpenWithDataSourceName(dsn *DataSourceName) (*DB, error) {
+	driverName, dbName := dsn.driverAndDbName()
+	return Open(driverName, dsn.String(), dbName, dsn.Dialect)
+}
+
+// Create a DB with an opened *sql.DB.
+func NewDB(driverName string, database *sql.DB, dialect Dialect) *DB {
+	d := newDB()
+	d.setSqlDb(driverName, database, dialect)
+	return d
+}
+
+func (d *DB) setSqlDb(driverName string, database *sql.DB, dialect Dialect) {
+	d.driver = driverName
+	d.dialect = dialect
+	d.sqlDb = database
+	d.sqlDb.SetMaxIdleConns(100)
+	d.mu.Lock()
+	d.stmtMap = make(map[string]*sql.Stmt)
+	d.closed = false
+	d.mu.Unlock()
+}
+
+func (d *DB) isClosed() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.closed
+}
+
+// Get an Qbs instance of this database, should call `defer q.Close()` next.
+func (d *DB) GetQbs() (q *Qbs, err error) {
+	return d.GetQbsContext(context.Background())
+}
+
+// GetQbsContext is like GetQbs, but it stops waiting for the connection limit when ctx is done,
+// and the returned Qbs instance performs every statement with ctx.
+func (d *DB) GetQbsContext(ctx context.Context) (q *Qbs, err error) {
+	if d.driver == "" || d.dialect == nil {
+		panic("database driver has not been registered, should call Register first.")
+	}
+	if d.isClosed() {
+		return nil, ErrDBClosed
+	}
+	if d.connectionLimit != nil {
+		if d.blockingOnLimit {
+			select {
+			case d.connectionLimit <- struct{}{}:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		} else {
+			select {
+			case d.connectionLimit <- struct{}{}:
+			default:
+				return nil, ConnectionLimitError
+			}
+		}
+	}
+	q = new(Qbs)
+	q.Dialect = d.dialect
+	q.criteria = new(criteria)
+	q.db = d
+	q.ctx = ctx
+	q.dryRun = d.dryRun
+	return q, nil
+}
+
+// A safe and easy way to work with *Qbs instance of this database without the need to open and close it.
+func (d *DB) WithQbs(task func(*Qbs) error) error {
+	q, err := d.GetQbs()
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+	return task(q)
+}
+
+// A safe and easy way to run task in a transaction of this database, see Qbs.Transaction.
+func (d *DB) WithTransaction(task func(*Qbs) error) error {
+	return d.WithQbs(func(q *Qbs) error {
+		return q.Transaction(task)
+	})
+}
+
+// Like WithTransaction, but retries task on deadlock or serialization failure, see Qbs.TransactionWithRetry.
+func (d *DB) WithTransactionRetry(maxRetries int, task func(*Qbs) error) error {
+	return d.WithQbs(func(q *Qbs) error {
+		return q.TransactionWithRetry(maxRetries, task)
+	})
+}
+
+// Get a Migration instance of this database, it should get closed like Qbs instance.
+// If the DB is created by NewDB, which has no data source name, the Migration shares the *sql.DB,
+// closing the Migration doesn't close it.
+func (d *DB) GetMigration() (mg *Migration, err error) {
+	if d.driver == "" || d.dialect == nil {
+		panic("database driver has not been registered, should call Register first.")
+	}
+	if d.isClosed() {
+		return nil, ErrDBClosed
+	}
+	if d.driverSource == "" {
+		return &Migration{db: d.sqlDb, dbName: d.dbName, dialect: d.dialect, shared: true}, nil
+	}
+	db, err := sql.Open(d.driver, d.driverSource)
+	if err != nil {
+		return nil, err
+	}
+	return &Migration{db: db, dbName: d.dbName, dialect: d.dialect}, nil
+}
+
+// A safe and easy way to work with Migration instance of this database without the need to open and close it.
+func (d *DB) WithMigration(task func(mg *Migration) error) error {
+	mg, err := d.GetMigration()
+	if err != nil {
+		return err
+	}
+	defer mg.Close()
+	return task(mg)
+}
+
+// The default connection pool size is 100.
+func (d *DB) ChangePoolSize(size int) {
+	d.sqlDb.SetMaxIdleConns(size)
+}
+
+func (d *DB) SetLogger(query *log.Logger, err *log.Logger) {
+	d.queryLogger = query
+	d.errorLogger = err
+}
+
+// Set the connection limit, there is no limit by default.
+// If blocking is true, GetQbs method will be blocked, otherwise returns ConnectionLimitError.
+func (d *DB) SetConnectionLimit(maxCon int, blocking bool) {
+	if maxCon > 0 {
+		d.connectionLimit = make(chan struct{}, maxCon)
+	} else if maxCon < 0 {
+		d.connectionLimit = nil
+	}
+	d.blockingOnLimit = blocking
+}
+
+// SetRetryBackoff sets the function which returns how long TransactionWithRetry waits before the retry,
+// retry starts from 1. The default backoff waits 10ms before the first retry, doubled for each following retry,
+// up to 1s. A nil backoff restores the default one.
+func (d *DB) SetRetryBackoff(backoff func(retry int) time.Duration) {
+	d.retryBackoff = backoff
+}
+
+// Close closes the cached statements and the underlying *sql.DB.
+// GetQbs and GetMigration return ErrDBClosed afterwards, the Qbs instances obtained before get the error of database/sql.
+func (d *DB) Close() error {
+	d.mu.Lock()
+	for _, v := range d.stmtMap {
+		v.Close()
+	}
+	d.stmtMap = make(map[string]*sql.Stmt)
+	closed := d.closed
+	d.closed = true
+	d.mu.Unlock()
+	if closed || d.sqlDb == nil {
+		return nil
+	}
+	return d.sqlDb.Close()
+}
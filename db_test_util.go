@@ -1,8 +1,10 @@
 package qbs
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 )
 
@@ -100,6 +102,130 @@ func doTestTransaction(assert *Assert) {
 
 }
 
+func doTestNestedTransaction(assert *Assert) {
+	setupBasicDb()
+	WithQbs(func(q *Qbs) error {
+		assert.MustNil(q.Begin())
+		_, err := q.Save(&basic{Name: "a"})
+		assert.Nil(err)
+
+		assert.MustNil(q.Begin())
+		_, err = q.Save(&basic{Name: "b"})
+		assert.Nil(err)
+		q.Exec("INSERT INTO no_such_table (id) VALUES (?)", 1)
+		assert.Nil(q.Rollback())
+
+		assert.MustNil(q.Begin())
+		_, err = q.Save(&basic{Name: "c"})
+		assert.Nil(err)
+		assert.Nil(q.Commit())
+		assert.True(q.InTransaction())
+		assert.Nil(q.Commit())
+		assert.True(!q.InTransaction())
+
+		var datas []*basic
+		err = q.OrderBy("id").FindAll(&datas)
+		assert.MustNil(err)
+		assert.MustEqual(2, len(datas))
+		assert.Equal("a", datas[0].Name)
+		assert.Equal("c", datas[1].Name)
+
+		q.Begin()
+		q.Begin()
+		q.Save(&basic{Name: "d"})
+		q.Commit()
+		q.Rollback()
+		assert.Equal(2, q.Count("basic"))
+		return nil
+	})
+}
+
+func doTestWithTransaction(assert *Assert, retryableErr error) {
+	setupBasicDb()
+	err := WithTransaction(func(q *Qbs) error {
+		_, err := q.Save(&basic{Name: "a"})
+		return err
+	})
+	assert.Nil(err)
+	err = WithTransaction(func(q *Qbs) error {
+		q.Save(&basic{Name: "b"})
+		return errors.New("abort")
+	})
+	assert.Equal("abort", err.Error())
+	func() {
+		defer func() {
+			assert.Equal("panic", recover())
+		}()
+		WithTransaction(func(q *Qbs) error {
+			q.Save(&basic{Name: "c"})
+			panic("panic")
+		})
+	}()
+	attempts := 0
+	var retries []int
+	SetRetryBackoff(func(retry int) time.Duration {
+		retries = append(retries, retry)
+		return time.Millisecond
+	})
+	err = WithTransactionRetry(3, func(q *Qbs) error {
+		attempts++
+		_, err := q.Save(&basic{Name: "d"})
+		if attempts < 3 {
+			return retryableErr
+		}
+		return err
+	})
+	SetRetryBackoff(nil)
+	assert.Nil(err)
+	assert.Equal(3, attempts)
+	assert.Equal([]int{1, 2}, retries)
+	assert.Equal(10*time.Millisecond, defaultRetryBackoff(1))
+	assert.Equal(20*time.Millisecond, defaultRetryBackoff(2))
+	assert.Equal(time.Second, defaultRetryBackoff(10))
+	attempts = 0
+	err = WithTransactionRetry(3, func(q *Qbs) error {
+		attempts++
+		return errors.New("not retryable")
+	})
+	assert.NotNil(err)
+	assert.Equal(1, attempts)
+	err = WithTransaction(func(q *Qbs) error {
+		q.Save(&basic{Name: "e"})
+		q.Count("no_such_table")
+		return nil
+	})
+	assert.True(err != nil)
+	WithQbs(func(q *Qbs) error {
+		var datas []*basic
+		err := q.OrderBy("id").FindAll(&datas)
+		assert.MustNil(err)
+		assert.MustEqual(2, len(datas))
+		assert.Equal("a", datas[0].Name)
+		assert.Equal("d", datas[1].Name)
+		return nil
+	})
+}
+
+func doTestBeginWith(assert *Assert) {
+	setupBasicDb()
+	WithQbs(func(q *Qbs) error {
+		err := q.BeginWith(&sql.TxOptions{Isolation: sql.LevelSnapshot})
+		assert.MustNil(err)
+		_, err = q.Save(&basic{Name: "a"})
+		assert.Nil(err)
+		assert.Nil(q.Commit())
+
+		err = q.BeginWith(&sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+		assert.MustNil(err)
+		b := new(basic)
+		err = q.Find(b)
+		assert.Nil(err)
+		assert.Equal("a", b.Name)
+		assert.Nil(q.Commit())
+		return nil
+	})
+}
+
 func doTestSaveAndDelete(assert *Assert, mg *Migration, q *Qbs) {
 	defer closeMigrationAndQbs(mg, q)
 	x := time.Now()
@@ -463,6 +589,532 @@ func doTestCount(assert *Assert) {
 	})
 }
 
+type basicStateCount struct {
+	State int64
+	Count int64 `qbs:"expr:COUNT(*)"`
+}
+
+func (*basicStateCount) TableName() string {
+	return "basic"
+}
+
+func doTestGroupBy(assert *Assert) {
+	setupBasicDb()
+	WithQbs(func(q *Qbs) error {
+		for i := 0; i < 6; i++ {
+			q.Save(&basic{Name: "name", State: int64(i % 3)})
+		}
+		q.Save(&basic{Name: "name", State: 0})
+		var counts []*basicStateCount
+		err := q.GroupBy("state").Having("COUNT(*) >= ?", 2).OrderByDesc("state").FindAll(&counts)
+		assert.MustNil(err)
+		assert.MustEqual(3, len(counts))
+		assert.Equal(2, counts[0].State)
+		assert.Equal(2, counts[0].Count)
+		assert.Equal(0, counts[2].State)
+		assert.Equal(3, counts[2].Count)
+		counts = nil
+		err = q.GroupBy("state").Having("COUNT(*) > ?", 2).FindAll(&counts)
+		assert.MustNil(err)
+		assert.MustEqual(1, len(counts))
+		assert.Equal(0, counts[0].State)
+		return nil
+	})
+}
+
+func doTestAggregate(assert *Assert) {
+	setupBasicDb()
+	WithQbs(func(q *Qbs) error {
+		sum := int64(1)
+		err := q.Sum("basic", "state", &sum)
+		assert.Nil(err)
+		assert.Equal(0, sum)
+		var max int64
+		err = q.Max("basic", "state", &max)
+		assert.Equal(sql.ErrNoRows, err)
+		for i := 0; i < 5; i++ {
+			q.Save(&basic{Name: fmt.Sprint("name", i%2), State: int64(i)})
+		}
+		err = q.Sum("basic", "state", &sum)
+		assert.Nil(err)
+		assert.Equal(10, sum)
+		var floatSum float64
+		err = q.WhereEqual("name", "name0").Sum(new(basic), "state", &floatSum)
+		assert.Nil(err)
+		assert.Equal(6, floatSum)
+		big := &basic{Name: "big", State: 1<<62 + 1}
+		q.Save(big)
+		err = q.WhereEqual("id", big.Id).Sum("basic", "state", &sum)
+		assert.Nil(err)
+		assert.Equal(1<<62+1, sum)
+		q.WhereEqual("id", big.Id).Delete(new(basic))
+		avg, err := q.Where("state > ?", 1).Avg("basic", "state")
+		assert.Nil(err)
+		assert.Equal(3, avg)
+		var min int64
+		err = q.Where("state > ?", 1).Min("basic", "state", &min)
+		assert.Nil(err)
+		assert.Equal(2, min)
+		err = q.Max("basic", "state", &max)
+		assert.Nil(err)
+		assert.Equal(4, max)
+		var maxName string
+		err = q.Max("basic", "name", &maxName)
+		assert.Nil(err)
+		assert.Equal("name1", maxName)
+		count, err := q.CountDistinct("basic", "name")
+		assert.Nil(err)
+		assert.Equal(2, count)
+		err = q.Sum("basic", "no_such_column", &sum)
+		assert.True(err != nil)
+		return nil
+	})
+}
+
+func doTestSelect(assert *Assert) {
+	setupBasicDb()
+	WithQbs(func(q *Qbs) error {
+		for i := 0; i < 4; i++ {
+			q.Save(&basic{Name: fmt.Sprint("name", i%2), State: int64(i)})
+		}
+		b := &basic{Id: 3}
+		err := q.Select("Name").Find(b)
+		assert.MustNil(err)
+		assert.Equal("name0", b.Name)
+		assert.Equal(0, b.State)
+		var datas []*basic
+		err = q.Select("Name").Distinct().OrderBy("name").FindAll(&datas)
+		assert.MustNil(err)
+		assert.MustEqual(2, len(datas))
+		assert.Equal("name0", datas[0].Name)
+		assert.Equal("name1", datas[1].Name)
+		assert.Equal(0, datas[1].Id)
+		return nil
+	})
+}
+
+func doTestTypedCondition(assert *Assert) {
+	setupBasicDb()
+	WithQbs(func(q *Qbs) error {
+		for i := 0; i < 4; i++ {
+			q.Save(&basic{Name: fmt.Sprint("Name", i), State: int64(i)})
+		}
+		var datas []*basic
+		err := q.Condition(Gte("state", 1).AndCondition(Lt("state", 3))).OrderBy("state").FindAll(&datas)
+		assert.MustNil(err)
+		assert.MustEqual(2, len(datas))
+		assert.Equal(1, datas[0].State)
+		datas = nil
+		err = q.Condition(ILike("name", "name%").AndCondition(Not(Eq("state", 0)))).FindAll(&datas)
+		assert.MustNil(err)
+		assert.Equal(3, len(datas))
+		count := q.Condition(Between("basic.state", 1, 2).OrCondition(IsNull("name"))).Count("basic")
+		assert.Equal(2, count)
+		return nil
+	})
+}
+
+func doTestWhereIn(assert *Assert) {
+	setupBasicDb()
+	WithQbs(func(q *Qbs) error {
+		for i := 0; i < 4; i++ {
+			q.Save(&basic{Name: fmt.Sprint("Name", i), State: int64(i)})
+		}
+		var datas []*basic
+		err := q.WhereIn("state", []int64{}).FindAll(&datas)
+		assert.Nil(err)
+		assert.Equal(0, len(datas))
+		err = q.WhereIn("state", []int64{1, 3}).OrderBy("state").FindAll(&datas)
+		assert.MustNil(err)
+		assert.MustEqual(2, len(datas))
+		assert.Equal(3, datas[1].State)
+		datas = nil
+		states := make([]int, q.Dialect.placeholderLimit()+10)
+		for i := range states {
+			states[i] = i + 2
+		}
+		err = q.WhereIn("state", states).FindAll(&datas)
+		assert.MustNil(err)
+		assert.Equal(2, len(datas))
+		count := q.Condition(NotIn("state", []string{})).Count("basic")
+		assert.Equal(4, count)
+		first := new(basic)
+		assert.MustNil(q.WhereEqual("state", 1).Find(first))
+		dup := &basic{Name: "Dup", State: 1}
+		_, err = q.Save(dup)
+		assert.MustNil(err)
+		ids := make([]int64, 2000)
+		for i := range ids {
+			ids[i] = int64(-i)
+		}
+		ids[0], ids[len(ids)-1] = first.Id, dup.Id
+		datas = nil
+		err = q.WhereIn("id", ids).Select("State").GroupBy("state").FindAll(&datas)
+		assert.MustNil(err)
+		assert.Equal(1, len(datas))
+		datas = nil
+		err = q.WhereIn("id", ids).Select("State").Distinct().FindAll(&datas)
+		assert.MustNil(err)
+		assert.Equal(1, len(datas))
+		ids[len(ids)-1] = first.Id
+		datas = nil
+		err = q.WhereIn("id", ids).FindAll(&datas)
+		assert.MustNil(err)
+		assert.Equal(1, len(datas))
+		return nil
+	})
+}
+
+func doTestSubquery(assert *Assert) {
+	type User struct {
+		Id   int64
+		Name string
+	}
+	type Post struct {
+		Id       int64
+		Title    string
+		Likes    int64
+		AuthorId int64
+		Author   *User
+	}
+	WithMigration(func(mg *Migration) error {
+		mg.dropTableIfExists(new(Post))
+		mg.dropTableIfExists(new(User))
+		mg.CreateTableIfNotExists(new(User))
+		mg.CreateTableIfNotExists(new(Post))
+		return nil
+	})
+	WithQbs(func(q *Qbs) error {
+		for i := 0; i < 3; i++ {
+			user := &User{Name: fmt.Sprint("user", i)}
+			q.Save(user)
+			for j := 0; j < i; j++ {
+				q.Save(&Post{Title: fmt.Sprintf("title%d%d", i, j), Likes: int64(i + j), AuthorId: user.Id})
+			}
+		}
+		var users []*User
+		sub := q.Select("AuthorId").Where("likes > ?", 2).Subquery(new(Post))
+		err := q.WhereInSubquery("id", sub).FindAll(&users)
+		assert.MustNil(err)
+		assert.MustEqual(1, len(users))
+		assert.Equal("user2", users[0].Name)
+
+		users = nil
+		sub = q.Select("Id").Where("post.author_id = user.id").Subquery(new(Post))
+		err = q.Condition(NotExists(sub)).FindAll(&users)
+		assert.MustNil(err)
+		assert.MustEqual(1, len(users))
+		assert.Equal("user0", users[0].Name)
+
+		var posts []*Post
+		sub = q.Select("Likes").Where("title = ?", "title10").Subquery(new(Post))
+		err = q.OmitJoin().Condition(CompareSubquery("likes", ">", sub).AndCondition(Lt("likes", 4))).FindAll(&posts)
+		assert.MustNil(err)
+		assert.MustEqual(2, len(posts))
+		return nil
+	})
+}
+
+func doTestPreload(assert *Assert) {
+	type Comment struct {
+		Id      int64
+		Content string
+		PostId  int64
+	}
+	type Post struct {
+		Id       int64
+		Title    string
+		AuthorId int64
+		Comments []*Comment
+	}
+	type User struct {
+		Id    int64
+		Name  string
+		Posts []*Post `qbs:"fk:AuthorId"`
+	}
+	WithMigration(func(mg *Migration) error {
+		mg.dropTableIfExists(new(Comment))
+		mg.dropTableIfExists(new(Post))
+		mg.dropTableIfExists(new(User))
+		mg.CreateTableIfNotExists(new(User))
+		mg.CreateTableIfNotExists(new(Post))
+		mg.CreateTableIfNotExists(new(Comment))
+		return nil
+	})
+	WithQbs(func(q *Qbs) error {
+		for i := 0; i < 3; i++ {
+			user := &User{Name: fmt.Sprint("user", i)}
+			q.Save(user)
+			for j := 0; j < i; j++ {
+				post := &Post{Title: fmt.Sprintf("title%d%d", i, j), AuthorId: user.Id}
+				q.Save(post)
+				for k := 0; k <= j; k++ {
+					q.Save(&Comment{Content: fmt.Sprintf("comment%d%d%d", i, j, k), PostId: post.Id})
+				}
+			}
+		}
+		var users []*User
+		err := q.Preload("Posts").OrderBy("id").FindAll(&users)
+		assert.MustNil(err)
+		assert.MustEqual(3, len(users))
+		assert.True(users[0].Posts != nil)
+		assert.Equal(0, len(users[0].Posts))
+		assert.MustEqual(1, len(users[1].Posts))
+		assert.Equal("title10", users[1].Posts[0].Title)
+		assert.MustEqual(2, len(users[2].Posts))
+		assert.Equal("title21", users[2].Posts[1].Title)
+		assert.True(users[2].Posts[1].Comments == nil)
+
+		user := &User{Id: users[2].Id}
+		err = q.Preload("Posts").Find(user)
+		assert.MustNil(err)
+		assert.Equal(2, len(user.Posts))
+
+		var posts []*Post
+		err = q.Preload("Comments").WhereEqual("author_id", users[2].Id).FindAll(&posts)
+		assert.MustNil(err)
+		assert.MustEqual(2, len(posts))
+		assert.Equal(1, len(posts[0].Comments))
+		assert.MustEqual(2, len(posts[1].Comments))
+		assert.Equal("comment211", posts[1].Comments[1].Content)
+		return nil
+	})
+}
+
+func doTestManyToMany(assert *Assert) {
+	type Tag struct {
+		Id   int64
+		Name string
+	}
+	type Post struct {
+		Id    int64
+		Title string
+		Tags  []*Tag `qbs:"m2m:post_tag"`
+	}
+	dropTables := func(mg *Migration) {
+		mg.dropTableIfExists("post_tag")
+		mg.dropTableIfExists(new(Post))
+		mg.dropTableIfExists(new(Tag))
+	}
+	WithMigration(func(mg *Migration) error {
+		dropTables(mg)
+		mg.CreateTableIfNotExists(new(Post))
+		assert.Equal(0, len(mg.dialect.columnsInTable(mg, "post_tag")))
+		mg.CreateTableIfNotExists(new(Tag))
+		assert.Equal(2, len(mg.dialect.columnsInTable(mg, "post_tag")))
+		dropTables(mg)
+		return mg.CreateTableIfNotExists(new(Post))
+	})
+	WithMigration(func(mg *Migration) error {
+		mg.CreateTableIfNotExists(new(Tag))
+		assert.Equal(0, len(mg.dialect.columnsInTable(mg, "post_tag")))
+		assert.Nil(mg.CreateJoinTableIfNotExists(new(Post), "Tags"))
+		assert.Equal(2, len(mg.dialect.columnsInTable(mg, "post_tag")))
+		return nil
+	})
+	WithMigration(func(mg *Migration) error {
+		dropTables(mg)
+		mg.CreateTableIfNotExists(new(Tag))
+		mg.CreateTableIfNotExists(new(Post))
+		columns := mg.dialect.columnsInTable(mg, "post_tag")
+		assert.Equal(2, len(columns))
+		assert.True(columns["post_id"] && columns["tag_id"])
+		return nil
+	})
+	WithQbs(func(q *Qbs) error {
+		a, b := &Tag{Name: "a"}, &Tag{Name: "b"}
+		q.Save(a)
+		q.Save(b)
+		post1, post2, post3 := &Post{Title: "post1"}, &Post{Title: "post2"}, &Post{Title: "post3"}
+		q.Save(post1)
+		q.Save(post2)
+		q.Save(post3)
+		_, err := q.Associate(post1, &Tag{Name: "unsaved"})
+		assert.True(err != nil)
+		for _, pair := range [][2]interface{}{{post1, a}, {post1, b}, {post2, b}} {
+			affected, err := q.Associate(pair[0], pair[1])
+			assert.MustNil(err)
+			assert.Equal(1, affected)
+		}
+		affected, err := q.Associate(post1, a)
+		assert.MustNil(err)
+		assert.Equal(0, affected)
+
+		var posts []*Post
+		err = q.Preload("Tags").OrderBy("id").FindAll(&posts)
+		assert.MustNil(err)
+		assert.MustEqual(3, len(posts))
+		assert.MustEqual(2, len(posts[0].Tags))
+		assert.Equal("b", posts[0].Tags[1].Name)
+		assert.MustEqual(1, len(posts[1].Tags))
+		assert.Equal("b", posts[1].Tags[0].Name)
+		assert.True(posts[2].Tags != nil)
+		assert.Equal(0, len(posts[2].Tags))
+
+		affected, err = q.Dissociate(post1, b)
+		assert.MustNil(err)
+		assert.Equal(1, affected)
+		post := &Post{Id: post1.Id}
+		err = q.Preload("Tags").Find(post)
+		assert.MustNil(err)
+		assert.MustEqual(1, len(post.Tags))
+		assert.Equal("a", post.Tags[0].Name)
+
+		var bulk []*Post
+		for i := 0; i < q.Dialect.placeholderLimit(); i++ {
+			bulk = append(bulk, &Post{Title: fmt.Sprint("bulk", i)})
+		}
+		assert.MustNil(q.BulkInsert(bulk))
+		_, err = q.Associate(bulk[len(bulk)-1], b)
+		assert.MustNil(err)
+		posts = nil
+		err = q.Preload("Tags").OrderBy("id").FindAll(&posts)
+		assert.MustNil(err)
+		assert.MustEqual(len(bulk)+3, len(posts))
+		assert.Equal(1, len(posts[0].Tags))
+		last := posts[len(posts)-1]
+		assert.MustEqual(1, len(last.Tags))
+		assert.Equal("b", last.Tags[0].Name)
+		return nil
+	})
+}
+
+func doTestNestedJoin(assert *Assert) {
+	type User struct {
+		Id   int64
+		Name string
+	}
+	type Post struct {
+		Id       int64
+		Title    string
+		AuthorId int64
+		Author   *User
+	}
+	type Comment struct {
+		Id      int64
+		Content string
+		PostId  int64
+		Post    *Post
+	}
+	WithMigration(func(mg *Migration) error {
+		mg.dropTableIfExists(new(Comment))
+		mg.dropTableIfExists(new(Post))
+		mg.dropTableIfExists(new(User))
+		mg.CreateTableIfNotExists(new(User))
+		mg.CreateTableIfNotExists(new(Post))
+		mg.CreateTableIfNotExists(new(Comment))
+		return nil
+	})
+	WithQbs(func(q *Qbs) error {
+		user := &User{Name: "john"}
+		q.Save(user)
+		post := &Post{Title: "qbs", AuthorId: user.Id}
+		q.Save(post)
+		comment := &Comment{Content: "nice", PostId: post.Id}
+		q.Save(comment)
+
+		found := &Comment{Id: comment.Id}
+		err := q.Find(found)
+		assert.MustNil(err)
+		assert.Equal("qbs", found.Post.Title)
+		assert.MustNil(found.Post.Author)
+
+		found = &Comment{Id: comment.Id}
+		err = q.JoinDepth(2).Find(found)
+		assert.MustNil(err)
+		assert.Equal("qbs", found.Post.Title)
+		assert.MustNotNil(found.Post.Author)
+		assert.Equal("john", found.Post.Author.Name)
+
+		var comments []*Comment
+		err = q.JoinDepth(2).Where("post___author.name = ?", "john").FindAll(&comments)
+		assert.MustNil(err)
+		assert.MustEqual(1, len(comments))
+		assert.Equal("john", comments[0].Post.Author.Name)
+		count, err := q.JoinDepth(2).Where("post___author.name = ?", "john").CountDistinct(new(Comment), "comment.id")
+		assert.MustNil(err)
+		assert.Equal(1, count)
+		return nil
+	})
+}
+
+type refUser struct {
+	Id   int64
+	Name string
+}
+
+// refTask references refUser three times, the reviewer's id field isn't named after the reference field.
+type refTask struct {
+	Id           int64
+	Title        string
+	CreatorId    int64 `qbs:"fk:Creator"`
+	Creator      *refUser
+	AssigneeId   int64 `qbs:"fk:Assignee"`
+	Assignee     *refUser
+	ReviewedById int64 `qbs:"fk:Reviewer"`
+	Reviewer     *refUser
+}
+
+type refCategory struct {
+	Id       int64
+	Name     string
+	ParentId sql.NullInt64 `qbs:"fk:Parent"`
+	Parent   *refCategory
+}
+
+func doTestMultipleRefs(assert *Assert) {
+	WithMigration(func(mg *Migration) error {
+		mg.dropTableIfExists(new(refTask))
+		mg.dropTableIfExists(new(refUser))
+		mg.dropTableIfExists(new(refCategory))
+		mg.CreateTableIfNotExists(new(refUser))
+		mg.CreateTableIfNotExists(new(refTask))
+		mg.CreateTableIfNotExists(new(refCategory))
+		return nil
+	})
+	WithQbs(func(q *Qbs) error {
+		alice, bob, carol := &refUser{Name: "alice"}, &refUser{Name: "bob"}, &refUser{Name: "carol"}
+		q.Save(alice)
+		q.Save(bob)
+		q.Save(carol)
+		task := &refTask{Title: "task", CreatorId: alice.Id, AssigneeId: bob.Id, ReviewedById: carol.Id}
+		_, err := q.Save(task)
+		assert.MustNil(err)
+		found := &refTask{Id: task.Id}
+		err = q.Find(found)
+		assert.MustNil(err)
+		assert.Equal("alice", found.Creator.Name)
+		assert.Equal("bob", found.Assignee.Name)
+		assert.Equal("carol", found.Reviewer.Name)
+		var tasks []*refTask
+		err = q.Where("assignee.name = ?", "bob").FindAll(&tasks)
+		assert.MustNil(err)
+		assert.MustEqual(1, len(tasks))
+		assert.Equal("carol", tasks[0].Reviewer.Name)
+
+		root := &refCategory{Name: "root"}
+		_, err = q.Save(root)
+		assert.MustNil(err)
+		child := &refCategory{Name: "child", ParentId: sql.NullInt64{Int64: root.Id, Valid: true}}
+		_, err = q.Save(child)
+		assert.MustNil(err)
+		grandchild := &refCategory{Name: "grandchild", ParentId: sql.NullInt64{Int64: child.Id, Valid: true}}
+		_, err = q.Save(grandchild)
+		assert.MustNil(err)
+		foundCategory := &refCategory{Id: grandchild.Id}
+		err = q.JoinDepth(2).Find(foundCategory)
+		assert.MustNil(err)
+		assert.Equal("child", foundCategory.Parent.Name)
+		assert.Equal("root", foundCategory.Parent.Parent.Name)
+		var children []*refCategory
+		err = q.Where("parent.name = ?", "root").FindAll(&children)
+		assert.MustNil(err)
+		assert.MustEqual(1, len(children))
+		assert.Equal("child", children[0].Name)
+		return nil
+	})
+}
+
 func doTestQueryMap(assert *Assert, mg *Migration, q *Qbs) {
 	defer closeMigrationAndQbs(mg, q)
 	type types struct {
@@ -515,6 +1167,508 @@ func doTestBulkInsert(assert *Assert) {
 	})
 }
 
+func doTestBulkInsertBatches(assert *Assert) {
+	setupBasicDb()
+	WithQbs(func(q *Qbs) error {
+		n := q.Dialect.placeholderLimit()/2 + 10
+		var bulk []*basic
+		for i := 0; i < n; i++ {
+			bulk = append(bulk, &basic{Name: fmt.Sprint("basic", i), State: int64(i)})
+		}
+		bulk[5].Id = int64(n + 100)
+		err := q.BulkInsert(bulk)
+		assert.MustNil(err)
+		assert.Equal(n, q.Count("basic"))
+		for i, b := range bulk {
+			if i == 5 {
+				assert.Equal(n+100, b.Id)
+				continue
+			}
+			found := &basic{Id: b.Id}
+			err = q.Find(found)
+			assert.MustNil(err)
+			assert.Equal(i, found.State)
+		}
+		return nil
+	})
+}
+
+type upsertTable struct {
+	Id      int64
+	Code    string `qbs:"size:32"`
+	Amount  int64
+	Created time.Time
+	Updated time.Time
+}
+
+func (table *upsertTable) Indexes(indexes *Indexes) {
+	indexes.AddUnique("code")
+}
+
+func doTestUpsert(assert *Assert) {
+	WithMigration(func(mg *Migration) error {
+		mg.dropTableIfExists(new(upsertTable))
+		mg.CreateTableIfNotExists(new(upsertTable))
+		return nil
+	})
+	WithQbs(func(q *Qbs) error {
+		first := &upsertTable{Code: "a", Amount: 1}
+		err := q.Upsert(first, "code")
+		assert.MustNil(err)
+		assert.Equal(1, first.Id)
+		assert.True(!first.Created.IsZero())
+		second := &upsertTable{Code: "a", Amount: 2}
+		err = q.Upsert(second, "code")
+		assert.MustNil(err)
+		assert.Equal(1, second.Id)
+		found := &upsertTable{Id: 1}
+		err = q.OmitFields("Created", "Updated").Find(found)
+		assert.MustNil(err)
+		assert.Equal(2, found.Amount)
+
+		err = q.Upsert(&upsertTable{Id: 1, Code: "a", Amount: 3})
+		assert.MustNil(err)
+		err = q.Upsert(&upsertTable{Code: "b", Amount: 4})
+		assert.MustNil(err)
+		assert.Equal(2, q.Count("upsert_table"))
+		found = &upsertTable{Id: 1}
+		err = q.OmitFields("Created", "Updated").Find(found)
+		assert.MustNil(err)
+		assert.Equal(3, found.Amount)
+		return nil
+	})
+}
+
+func doTestInsert(assert *Assert) {
+	setupBasicDb()
+	WithQbs(func(q *Qbs) error {
+		b := &basic{Id: 10, Name: "basic", State: 1}
+		affected, err := q.Insert(b)
+		assert.MustNil(err)
+		assert.Equal(1, affected)
+		b = &basic{Name: "basic", State: 2}
+		_, err = q.Insert(b)
+		assert.MustNil(err)
+		assert.True(b.Id > 10)
+		_, err = q.Insert(&basic{Id: 10, Name: "duplicated", State: 3})
+		var dupErr *DuplicateKeyError
+		assert.True(errors.As(err, &dupErr))
+		assert.Equal(2, q.Count("basic"))
+		return nil
+	})
+}
+
+func doTestUpdateExpr(assert *Assert) {
+	setupBasicDb()
+	WithQbs(func(q *Qbs) error {
+		b := &basic{Name: "basic", State: 10}
+		q.Save(b)
+		q.Save(&basic{Name: "other", State: 10})
+		affected, err := q.WhereEqual("id", b.Id).Increment("basic", "state", 5)
+		assert.MustNil(err)
+		assert.Equal(1, affected)
+		affected, err = q.WhereEqual("name", "other").Decrement(new(basic), "state", 3)
+		assert.MustNil(err)
+		assert.Equal(1, affected)
+		affected, err = q.Where("state > ?", 0).UpdateExpr("basic", map[string]Expr{
+			"state": Raw("state * ?", 2),
+			"name":  Raw("?", "updated"),
+		})
+		assert.MustNil(err)
+		assert.Equal(2, affected)
+		var datas []*basic
+		err = q.OrderBy("id").FindAll(&datas)
+		assert.MustNil(err)
+		assert.MustEqual(2, len(datas))
+		assert.Equal(30, datas[0].State)
+		assert.Equal(14, datas[1].State)
+		assert.Equal("updated", datas[1].Name)
+		affected, err = q.Increment(b, "state", 1)
+		assert.MustNil(err)
+		assert.Equal(1, affected)
+		found := &basic{Id: b.Id}
+		assert.MustNil(q.Find(found))
+		assert.Equal(31, found.State)
+		return nil
+	})
+}
+
+func doTestUpdateColumns(assert *Assert) {
+	setupBasicDb()
+	WithQbs(func(q *Qbs) error {
+		b := &basic{Name: "basic", State: 1}
+		q.Save(b)
+		q.Save(&basic{Name: "other", State: 2})
+		affected, err := q.WhereEqual("name", "other").UpdateColumns("basic", map[string]interface{}{"state": 0})
+		assert.MustNil(err)
+		assert.Equal(1, affected)
+		affected, err = q.UpdateColumns(&basic{Id: b.Id}, map[string]interface{}{"name": "renamed"})
+		assert.MustNil(err)
+		assert.Equal(1, affected)
+		found := &basic{Id: b.Id}
+		err = q.Find(found)
+		assert.MustNil(err)
+		assert.Equal("renamed", found.Name)
+		assert.Equal(1, found.State)
+		assert.Equal(1, q.WhereEqual("state", 0).Count("basic"))
+		return nil
+	})
+}
+
+func doTestDirtyTracking(assert *Assert) {
+	setupBasicDb()
+	WithQbs(func(q *Qbs) error {
+		q.Save(&basic{Name: "basic", State: 1})
+		q.Save(&basic{Name: "other", State: 1})
+		var datas []*basic
+		err := q.OrderBy("id").FindAll(&datas)
+		assert.MustNil(err)
+		assert.MustEqual(2, len(datas))
+		affected, err := q.Update(datas[0])
+		assert.MustNil(err)
+		assert.Equal(0, affected)
+
+		q.WhereEqual("id", datas[0].Id).UpdateColumns("basic", map[string]interface{}{"state": 2})
+		datas[0].Name = "renamed"
+		affected, err = q.Update(datas[0])
+		assert.MustNil(err)
+		assert.Equal(1, affected)
+		found := &basic{Id: datas[0].Id}
+		q.Find(found)
+		assert.Equal("renamed", found.Name)
+		assert.Equal(2, found.State)
+
+		q.WhereEqual("id", datas[1].Id).UpdateColumns("basic", map[string]interface{}{"state": 2})
+		datas[1].Name = "saved"
+		_, err = q.Save(datas[1])
+		assert.MustNil(err)
+		found = &basic{Id: datas[1].Id}
+		q.Find(found)
+		assert.Equal("saved", found.Name)
+		assert.Equal(2, found.State)
+
+		datas = nil
+		q.DisableTracking().OrderBy("id").FindAll(&datas)
+		datas[0].Name = "untracked"
+		q.WhereEqual("id", datas[0].Id).UpdateColumns("basic", map[string]interface{}{"state": 3})
+		_, err = q.Update(datas[0])
+		assert.MustNil(err)
+		found = &basic{Id: datas[0].Id}
+		q.Find(found)
+		assert.Equal(2, found.State)
+		return nil
+	})
+	WithQbs(func(q *Qbs) error {
+		b := new(basic)
+		assert.MustNil(q.WhereEqual("name", "saved").Find(b))
+		b.Name = "rolled back"
+		assert.MustNil(q.Begin())
+		affected, err := q.Update(b)
+		assert.MustNil(err)
+		assert.Equal(1, affected)
+		assert.MustNil(q.Rollback())
+		affected, err = q.Update(b)
+		assert.MustNil(err)
+		assert.Equal(1, affected)
+		found := &basic{Id: b.Id}
+		q.Find(found)
+		assert.Equal("rolled back", found.Name)
+
+		b.Name = "savepoint"
+		assert.MustNil(q.Begin())
+		err = q.Transaction(func(q *Qbs) error {
+			_, err := q.Update(b)
+			assert.Nil(err)
+			return errors.New("abort")
+		})
+		assert.NotNil(err)
+		affected, err = q.Update(b)
+		assert.MustNil(err)
+		assert.Equal(1, affected)
+		assert.MustNil(q.Commit())
+		found = &basic{Id: b.Id}
+		q.Find(found)
+		assert.Equal("savepoint", found.Name)
+
+		copied := new(basic)
+		assert.MustNil(q.WhereEqual("name", "savepoint").Find(copied))
+		other := new(basic)
+		assert.MustNil(q.Condition(Ne("id", copied.Id)).Find(other))
+		q.WhereEqual("id", other.Id).UpdateColumns("basic", map[string]interface{}{"state": 5})
+		copied.Id = other.Id
+		copied.Name = "copy"
+		_, err = q.Update(copied)
+		assert.MustNil(err)
+		found = &basic{Id: other.Id}
+		q.Find(found)
+		assert.Equal("copy", found.Name)
+		assert.Equal(copied.State, found.State)
+		return nil
+	})
+
+	type trackedTable struct {
+		Id      int64
+		Name    string
+		Updated time.Time
+	}
+	WithMigration(func(mg *Migration) error {
+		mg.dropTableIfExists(new(trackedTable))
+		return mg.CreateTableIfNotExists(new(trackedTable))
+	})
+	WithQbs(func(q *Qbs) error {
+		t := &trackedTable{Name: "a"}
+		_, err := q.Save(t)
+		assert.MustNil(err)
+		q.snapshot(t)
+		t.Name = "b"
+		_, err = q.Save(t)
+		assert.MustNil(err)
+		assert.Equal(t.Updated, q.snapshots[t]["updated"])
+		return nil
+	})
+}
+
+type versionedTable struct {
+	Id      int64
+	Name    string
+	Version int64 `qbs:"version"`
+}
+
+func doTestOptimisticLocking(assert *Assert) {
+	WithMigration(func(mg *Migration) error {
+		mg.dropTableIfExists(new(versionedTable))
+		mg.CreateTableIfNotExists(new(versionedTable))
+		return nil
+	})
+	WithQbs(func(q *Qbs) error {
+		v := &versionedTable{Name: "origin"}
+		_, err := q.Save(v)
+		assert.MustNil(err)
+		a := &versionedTable{Id: v.Id}
+		b := &versionedTable{Id: v.Id}
+		q.Find(a)
+		q.Find(b)
+		a.Name = "a"
+		affected, err := q.Update(a)
+		assert.MustNil(err)
+		assert.Equal(1, affected)
+		assert.Equal(1, a.Version)
+		b.Name = "b"
+		_, err = q.Update(b)
+		assert.Equal(ErrStaleObject, err)
+		_, err = q.Save(b)
+		assert.Equal(ErrStaleObject, err)
+		assert.Equal(0, b.Version)
+
+		err = q.Find(b)
+		assert.MustNil(err)
+		assert.Equal("a", b.Name)
+		b.Name = "b"
+		_, err = q.Save(b)
+		assert.MustNil(err)
+		assert.Equal(2, b.Version)
+		found := &versionedTable{Id: v.Id}
+		q.Find(found)
+		assert.Equal("b", found.Name)
+		assert.Equal(2, found.Version)
+		found.Name = "c"
+		_, args, err := q.ToSQL(OpUpdate, found)
+		assert.MustNil(err)
+		assert.Equal(fmt.Sprint([]interface{}{"c", 3, v.Id, 2}), args)
+
+		a.Name = "stale"
+		assert.MustNil(q.Begin())
+		_, err = q.Update(a)
+		assert.Equal(ErrStaleObject, err)
+		assert.Equal(ErrStaleObject, q.Commit())
+		return nil
+	})
+}
+
+type softDeleteTable struct {
+	Id      int64
+	Name    string
+	Deleted *time.Time `qbs:"deleted"`
+}
+
+func doTestSoftDelete(assert *Assert) {
+	WithMigration(func(mg *Migration) error {
+		mg.dropTableIfExists(new(softDeleteTable))
+		mg.CreateTableIfNotExists(new(softDeleteTable))
+		return nil
+	})
+	WithQbs(func(q *Qbs) error {
+		for _, name := range []string{"a", "b", "c"} {
+			_, err := q.Save(&softDeleteTable{Name: name})
+			assert.MustNil(err)
+		}
+		a := &softDeleteTable{Id: 1}
+		affected, err := q.Delete(a)
+		assert.MustNil(err)
+		assert.Equal(1, affected)
+		assert.True(a.Deleted != nil)
+		affected, err = q.Delete(&softDeleteTable{Id: 1})
+		assert.MustNil(err)
+		assert.Equal(0, affected)
+
+		assert.Equal(sql.ErrNoRows, q.Find(&softDeleteTable{Id: 1}))
+		var all []*softDeleteTable
+		err = q.Select("Id", "Name").FindAll(&all)
+		assert.MustNil(err)
+		assert.Equal(2, len(all))
+		assert.Equal(2, q.Count(new(softDeleteTable)))
+		assert.Equal(3, q.WithDeleted().Count(new(softDeleteTable)))
+		assert.Equal(3, q.Count("soft_delete_table"))
+		all = nil
+		err = q.OnlyDeleted().Select("Id", "Name").FindAll(&all)
+		assert.MustNil(err)
+		assert.MustEqual(1, len(all))
+		assert.Equal("a", all[0].Name)
+		var names []string
+		row := new(softDeleteTable)
+		err = q.WithDeleted().Select("Id", "Name").OrderBy("id").Iterate(row, func() error {
+			names = append(names, row.Name)
+			return nil
+		})
+		assert.MustNil(err)
+		assert.Equal("[a b c]", names)
+
+		affected, err = q.Restore(a)
+		assert.MustNil(err)
+		assert.Equal(1, affected)
+		assert.True(a.Deleted == nil)
+		assert.Equal(3, q.Count(new(softDeleteTable)))
+
+		affected, err = q.HardDelete(&softDeleteTable{Id: 2})
+		assert.MustNil(err)
+		assert.Equal(1, affected)
+		assert.Equal(0, q.WithDeleted().WhereEqual("id", 2).Count(new(softDeleteTable)))
+		return nil
+	})
+	WithQbs(func(q *Qbs) error {
+		a := &softDeleteTable{Id: 1}
+		affected, err := q.Delete(a)
+		assert.MustNil(err)
+		assert.Equal(1, affected)
+		_, err = q.Save(&softDeleteTable{Id: a.Id, Name: "resaved"})
+		assert.MustNil(err)
+		found := new(softDeleteTable)
+		err = q.WithDeleted().Select("Id", "Name").WhereEqual("id", a.Id).Find(found)
+		assert.MustNil(err)
+		assert.Equal("resaved", found.Name)
+		return nil
+	})
+}
+
+type hookTable struct {
+	Id    int64
+	Name  string
+	calls []string
+}
+
+var errHookName = errors.New("invalid name")
+
+func (h *hookTable) BeforeInsert(q *Qbs) error {
+	if h.Name == "invalid" {
+		return errHookName
+	}
+	h.calls = append(h.calls, "BeforeInsert")
+	return nil
+}
+
+func (h *hookTable) AfterInsert(q *Qbs) error {
+	h.calls = append(h.calls, "AfterInsert")
+	return nil
+}
+
+func (h *hookTable) BeforeUpdate(q *Qbs) error {
+	// the criteria of the update is kept after the hook queries.
+	if q.WhereEqual("name", "invalid").Count("hook_table") > 0 {
+		return errHookName
+	}
+	h.calls = append(h.calls, "BeforeUpdate")
+	return nil
+}
+
+func (h *hookTable) AfterUpdate(q *Qbs) error {
+	h.calls = append(h.calls, "AfterUpdate")
+	return nil
+}
+
+func (h *hookTable) BeforeDelete(q *Qbs) error {
+	h.calls = append(h.calls, "BeforeDelete")
+	return nil
+}
+
+func (h *hookTable) AfterDelete(q *Qbs) error {
+	h.calls = append(h.calls, "AfterDelete")
+	return nil
+}
+
+func (h *hookTable) AfterFind(q *Qbs) error {
+	h.calls = append(h.calls, "AfterFind")
+	return nil
+}
+
+func doTestHooks(assert *Assert) {
+	WithMigration(func(mg *Migration) error {
+		mg.dropTableIfExists(new(hookTable))
+		mg.CreateTableIfNotExists(new(hookTable))
+		return nil
+	})
+	WithQbs(func(q *Qbs) error {
+		h := &hookTable{Name: "a"}
+		_, err := q.Save(h)
+		assert.MustNil(err)
+		h.Name = "b"
+		_, err = q.Save(h)
+		assert.MustNil(err)
+		_, err = q.Update(h)
+		assert.MustNil(err)
+		assert.Equal("[BeforeInsert AfterInsert BeforeUpdate AfterUpdate BeforeUpdate AfterUpdate]", h.calls)
+
+		_, err = q.Insert(&hookTable{Name: "invalid"})
+		assert.Equal(errHookName, err)
+		assert.Equal(1, q.Count("hook_table"))
+
+		hooks := []*hookTable{{Name: "c"}, {Name: "d"}}
+		err = q.BulkInsert(hooks)
+		assert.MustNil(err)
+		assert.Equal("[BeforeInsert AfterInsert]", hooks[1].calls)
+
+		found := &hookTable{Id: h.Id}
+		err = q.Find(found)
+		assert.MustNil(err)
+		assert.Equal("b", found.Name)
+		assert.Equal("[AfterFind]", found.calls)
+		var all []*hookTable
+		err = q.FindAll(&all)
+		assert.MustNil(err)
+		assert.MustEqual(3, len(all))
+		assert.Equal("[AfterFind]", all[2].calls)
+		row := new(hookTable)
+		err = q.Iterate(row, func() error {
+			return nil
+		})
+		assert.MustNil(err)
+		assert.Equal("[AfterFind AfterFind AfterFind]", row.calls)
+
+		_, err = q.Delete(found)
+		assert.MustNil(err)
+		assert.Equal("[AfterFind BeforeDelete AfterDelete]", found.calls)
+		return nil
+	})
+	WithQbs(func(q *Qbs) error {
+		q.Begin()
+		_, err := q.Save(&hookTable{Name: "invalid"})
+		assert.Equal(errHookName, err)
+		_, err = q.Save(&hookTable{Name: "e"})
+		assert.MustNil(err)
+		assert.Equal(errHookName, q.Commit())
+		return nil
+	})
+}
+
 func doTestQueryStruct(assert *Assert) {
 	setupBasicDb()
 	WithQbs(func(q *Qbs) error {
@@ -555,7 +1709,38 @@ func doTestConnectionLimit(assert *Assert) {
 	GetQbs()
 	assert.Equal(1, a)
 	SetConnectionLimit(-1, false)
-	assert.Nil(connectionLimit)
+	assert.Nil(defaultDB.connectionLimit)
+}
+
+func doTestContext(assert *Assert) {
+	setupBasicDb()
+	WithQbs(func(q *Qbs) error {
+		_, err := q.Save(&basic{Name: "a", State: 1})
+		assert.MustNil(err)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		b := new(basic)
+		err = q.WithContext(ctx).Find(b)
+		assert.Equal(context.Canceled, err)
+		_, err = q.Save(&basic{Name: "b", State: 2})
+		assert.Equal(context.Canceled, err)
+		err = q.WithContext(context.Background()).Find(b)
+		assert.Nil(err)
+		assert.Equal("a", b.Name)
+		return nil
+	})
+	SetConnectionLimit(1, true)
+	defer SetConnectionLimit(-1, false)
+	q0, _ := GetQbs()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	q1, err := GetQbsContext(ctx)
+	assert.Nil(q1)
+	assert.Equal(context.DeadlineExceeded, err)
+	q0.Close()
+	q1, err = GetQbsContext(context.Background())
+	assert.MustNil(err)
+	q1.Close()
 }
 
 func doTestIterate(assert *Assert) {
@@ -579,6 +1764,67 @@ func doTestIterate(assert *Assert) {
 	assert.Equal(3, stateSum)
 }
 
+func doTestMultipleDB(assert *Assert, other *DB) {
+	defer other.Close()
+	setupBasicDb()
+	err := other.WithMigration(func(mg *Migration) error {
+		b := new(basic)
+		mg.dropTableIfExists(b)
+		return mg.CreateTableIfNotExists(b)
+	})
+	assert.MustNil(err)
+	WithQbs(func(q *Qbs) error {
+		_, err := q.Save(&basic{Name: "default", State: 1})
+		assert.Nil(err)
+		return nil
+	})
+	other.WithQbs(func(q *Qbs) error {
+		assert.Equal(0, q.Count("basic"))
+		_, err := q.Save(&basic{Name: "other", State: 2})
+		assert.Nil(err)
+		b := new(basic)
+		err = q.Find(b)
+		assert.Nil(err)
+		assert.Equal("other", b.Name)
+		return nil
+	})
+	WithQbs(func(q *Qbs) error {
+		b := new(basic)
+		err := q.Find(b)
+		assert.Nil(err)
+		assert.Equal("default", b.Name)
+		assert.Equal(1, q.Count("basic"))
+		return nil
+	})
+}
+
+func doTestNewDB(assert *Assert, other *DB) {
+	mg, err := other.GetMigration()
+	assert.MustNil(err)
+	assert.True(mg.db == other.sqlDb)
+	b := new(basic)
+	mg.dropTableIfExists(b)
+	assert.Nil(mg.CreateTableIfNotExists(b))
+	mg.Close()
+	err = other.WithQbs(func(q *Qbs) error {
+		_, err := q.Save(&basic{Name: "new", State: 1})
+		return err
+	})
+	assert.Nil(err)
+	q, err := other.GetQbs()
+	assert.MustNil(err)
+	defer q.Close()
+	assert.Nil(other.Close())
+	assert.Nil(other.Close())
+	err = q.Find(new(basic))
+	assert.NotNil(err)
+	assert.NotEqual(sql.ErrNoRows, err)
+	_, err = other.GetQbs()
+	assert.Equal(ErrDBClosed, err)
+	_, err = other.GetMigration()
+	assert.Equal(ErrDBClosed, err)
+}
+
 func setupBasicDb() {
 	WithMigration(func(mg *Migration) error {
 		b := new(basic)
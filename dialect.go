@@ -1,6 +1,7 @@
 package qbs
 
 import (
+	"database/sql"
 	"fmt"
 	"reflect"
 	"strings"
@@ -22,14 +23,40 @@ type Dialect interface {
 
 	querySql(criteria *criteria) (sql string, args []interface{})
 
+	// Like querySql, but the markers are not substituted, so it can be embedded in another statement.
+	selectSql(criteria *criteria) (sql string, args []interface{})
+
+	aggregateSql(criteria *criteria, expr string) (sql string, args []interface{})
+
+	// The max number of placeholders in a statement.
+	placeholderLimit() int
+
+	// The max number of values in an IN list, 0 means no limit.
+	maxInValues() int
+
+	// Case insensitive like condition on the quoted column.
+	iLikeSql(column string) string
+
 	insert(q *Qbs) (int64, error)
 
 	insertSql(criteria *criteria) (sql string, args []interface{})
 
+	// Insert the models which have the same columns in one statement, returns the generated ids if available.
+	bulkInsert(q *Qbs, models []*model) ([]int64, error)
+
+	bulkInsertSql(models []*model) (sql string, args []interface{})
+
+	// Insert the model, or update it on conflict of the columns, returns the id of the row if available.
+	upsert(q *Qbs, conflictColumns []string) (int64, error)
+
+	upsertSql(criteria *criteria, conflictColumns []string) (sql string, args []interface{})
+
 	update(q *Qbs) (int64, error)
 
 	updateSql(criteria *criteria) (string, []interface{})
 
+	updateExprSql(criteria *criteria, exprs map[string]Expr) (string, []interface{})
+
 	delete(q *Qbs) (int64, error)
 
 	deleteSql(criteria *criteria) (string, []interface{})
@@ -49,6 +76,22 @@ type Dialect interface {
 	primaryKeySql(isString bool, size int) string
 
 	catchMigrationError(err error) bool
+
+	savepointSql(name string) string
+
+	// Returns empty string if the database releases savepoints implicitly.
+	releaseSavepointSql(name string) string
+
+	rollbackToSavepointSql(name string) string
+
+	// Replaces the isolation level which is not supported by the database with a stronger one.
+	txOptions(opts sql.TxOptions) sql.TxOptions
+
+	// Reports whether the error is a deadlock or serialization failure, so the transaction can be retried.
+	isRetryableError(err error) bool
+
+	// Reports whether the error is a violation of the primary key or an unique index.
+	isDuplicateKeyError(err error) bool
 }
 
 type DataSourceName struct {
@@ -121,34 +164,36 @@ func (dsn *DataSourceName) Append(key, value string) *DataSourceName {
 	return dsn
 }
 
-func RegisterWithDataSourceName(dsn *DataSourceName) {
-	var driverName string
-	mustCloseDBForNewDatasource := false
+func (dsn *DataSourceName) driverAndDbName() (driverName, dbName string) {
 	switch dsn.Dialect.(type) {
 	case *mysql:
 		driverName = "mysql"
 	case *sqlite3:
 		driverName = "sqlite3"
-		mustCloseDBForNewDatasource = true
 	case *postgres:
 		driverName = "postgres"
-		mustCloseDBForNewDatasource = true
 	}
-	dbName := dsn.DbName
+	dbName = dsn.DbName
 	if driverName == "sqlite3" {
 		dbName = ""
 	}
+	return
+}
+
+func RegisterWithDataSourceName(dsn *DataSourceName) {
+	driverName, dbName := dsn.driverAndDbName()
+	mustCloseDBForNewDatasource := driverName == "sqlite3" || driverName == "postgres"
 
 	//XXX This appears to something related to the specific way the tests
 	//XXX run and the db variable.  If the tests are run independently (with -test.run)
 	//XXX then the tests pass.  However, they fail if the database has already
 	//XXX been Registered and the db variable is not nil.
 	//XXX This is only needed for postgres and sqlite3.
-	if mustCloseDBForNewDatasource && db != nil {
-		if err := db.Close(); err != nil {
+	if mustCloseDBForNewDatasource && defaultDB.sqlDb != nil {
+		if err := defaultDB.sqlDb.Close(); err != nil {
 			panic(err)
 		}
-		db = nil
+		defaultDB.sqlDb = nil
 	}
 	Register(driverName, dsn.String(), dbName, dsn.Dialect)
 }
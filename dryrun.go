@@ -0,0 +1,149 @@
+package qbs
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Statement is a SQL statement recorded in dry run mode, the markers are substituted by the dialect.
+type Statement struct {
+	SQL  string
+	Args []interface{}
+}
+
+// DryRun makes the Qbs instance record statements instead of performing them, it should be called before Begin.
+// In dry run mode, queries return no rows, and updates and deletes affect no rows,
+// the recorded statements can be obtained by Statements.
+func (q *Qbs) DryRun() *Qbs {
+	q.dryRun = true
+	q.statements = nil
+	return q
+}
+
+// Statements returns the statements recorded in dry run mode.
+func (q *Qbs) Statements() []Statement {
+	return q.statements
+}
+
+// NewDryRunDB creates a DB which doesn't connect to any database, the Qbs instances it returns are in dry run mode,
+// so the generated SQL of the dialect can be reviewed or asserted in unit tests.
+func NewDryRunDB(dialect Dialect) *DB {
+	dryRunSink() // registers the driver.
+	database, _ := sql.Open(dryRunDriverName, "")
+	d := NewDB(dryRunDriverName, database, dialect)
+	d.dryRun = true
+	return d
+}
+
+const dryRunDriverName = "qbs_dry_run"
+
+var (
+	dryRunOnce sync.Once
+	dryRunDB   *DB
+)
+
+// dryRunSink returns the DB which performs the statements of the Qbs instances switched by DryRun,
+// it's never closed.
+func dryRunSink() *DB {
+	dryRunOnce.Do(func() {
+		sql.Register(dryRunDriverName, dryRunDriver{})
+		database, _ := sql.Open(dryRunDriverName, "")
+		dryRunDB = newDB()
+		dryRunDB.setSqlDb(dryRunDriverName, database, nil)
+	})
+	return dryRunDB
+}
+
+// dryRunDriver is a database driver which doesn't perform any statement.
+type dryRunDriver struct{}
+
+func (dryRunDriver) Open(name string) (driver.Conn, error) {
+	return dryRunConn{}, nil
+}
+
+type dryRunConn struct{}
+
+func (dryRunConn) Prepare(query string) (driver.Stmt, error) {
+	return dryRunStmt{strings.Contains(query, " RETURNING ")}, nil
+}
+
+func (dryRunConn) Close() error {
+	return nil
+}
+
+func (dryRunConn) Begin() (driver.Tx, error) {
+	return dryRunConn{}, nil
+}
+
+func (dryRunConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return dryRunConn{}, nil
+}
+
+func (dryRunConn) Commit() error {
+	return nil
+}
+
+func (dryRunConn) Rollback() error {
+	return nil
+}
+
+// dryRunStmt affects no rows and returns no rows,
+// except that an insert statement returning the primary key returns a zero key.
+type dryRunStmt struct {
+	returning bool
+}
+
+func (s dryRunStmt) Close() error {
+	return nil
+}
+
+func (s dryRunStmt) NumInput() int {
+	return -1
+}
+
+func (s dryRunStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return dryRunResult{}, nil
+}
+
+func (s dryRunStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &dryRunRows{returning: s.returning}, nil
+}
+
+type dryRunResult struct{}
+
+func (dryRunResult) LastInsertId() (int64, error) {
+	return 0, nil
+}
+
+func (dryRunResult) RowsAffected() (int64, error) {
+	return 0, nil
+}
+
+type dryRunRows struct {
+	returning bool
+	done      bool
+}
+
+func (r *dryRunRows) Columns() []string {
+	if r.returning {
+		return []string{"id"}
+	}
+	return []string{}
+}
+
+func (r *dryRunRows) Close() error {
+	return nil
+}
+
+func (r *dryRunRows) Next(dest []driver.Value) error {
+	if !r.returning || r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = int64(0)
+	return nil
+}
@@ -0,0 +1,220 @@
+package qbs
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestToSQL(t *testing.T) {
+	assert := NewAssert(t)
+	q, _ := NewDryRunDB(NewPostgres()).GetQbs()
+	defer q.Close()
+	query, args, err := q.Where("amount > ?", 5).ToSQL(OpFind, &sqlGenModel{Prim: 3})
+	assert.MustNil(err)
+	assert.Equal(`SELECT "prim", "first", "last", "amount" FROM "sql_gen_model" WHERE ("sql_gen_model"."prim" = $1) AND (amount > $2) LIMIT $3`, query)
+	assert.Equal("[3 5 1]", args)
+	var models []*sqlGenModel
+	query, args, err = q.Condition(Eq("first", "a")).OrderBy("last").ToSQL(OpFindAll, &models)
+	assert.MustNil(err)
+	assert.Equal(`SELECT "prim", "first", "last", "amount" FROM "sql_gen_model" WHERE "first" = $1 ORDER BY "last"`, query)
+	assert.Equal("[a]", args)
+	query, args, err = q.ToSQL(OpSave, &sqlGenModel{First: "a", Amount: 2})
+	assert.MustNil(err)
+	assert.Equal(`INSERT INTO "sql_gen_model" ("first", "last", "amount") VALUES ($1, $2, $3) RETURNING "prim"`, query)
+	assert.Equal("[a  2]", args)
+	query, _, err = q.ToSQL(OpSave, sqlGenSampleData)
+	assert.MustNil(err)
+	assert.Equal(`UPDATE "sql_gen_model" SET "first" = $1, "last" = $2, "amount" = $3 WHERE prim = $4`, query)
+	query, args, err = q.ToSQL(OpDelete, sqlGenSampleData)
+	assert.MustNil(err)
+	assert.Equal(`DELETE FROM "sql_gen_model" WHERE "prim" = $1`, query)
+	assert.Equal("[3]", args)
+	_, _, err = q.ToSQL(OpUpdate, new(sqlGenModel))
+	assert.True(err != nil)
+	_, args, err = q.ToSQL(OpSave, &upsertModel{Code: "a"})
+	assert.MustNil(err)
+	assert.MustEqual(3, len(args))
+	created, ok := args[2].(time.Time)
+	assert.True(ok && !created.IsZero())
+}
+
+func TestDryRun(t *testing.T) {
+	assert := NewAssert(t)
+	q, _ := NewDryRunDB(NewSqlite3()).GetQbs()
+	defer q.Close()
+	model := &sqlGenModel{First: "a"}
+	affected, err := q.Save(model)
+	assert.MustNil(err)
+	assert.Equal(1, affected)
+	err = q.Find(sqlGenSampleData)
+	assert.Equal(sql.ErrNoRows, err)
+	affected, err = q.Delete(sqlGenSampleData)
+	assert.MustNil(err)
+	assert.Equal(0, affected)
+	statements := q.Statements()
+	assert.MustEqual(3, len(statements))
+	assert.Equal("INSERT INTO `sql_gen_model` (`first`, `last`, `amount`) VALUES (?, ?, ?)", statements[0].SQL)
+	assert.Equal("DELETE FROM `sql_gen_model` WHERE `prim` = ?", statements[2].SQL)
+	assert.Equal("[3]", statements[2].Args)
+
+	pq, _ := NewDryRunDB(NewPostgres()).GetQbs()
+	defer pq.Close()
+	err = pq.Transaction(func(tx *Qbs) error {
+		_, err := tx.Save(model)
+		return err
+	})
+	assert.MustNil(err)
+	statements = pq.Statements()
+	assert.MustEqual(1, len(statements))
+	assert.Equal(`INSERT INTO "sql_gen_model" ("first", "last", "amount") VALUES ($1, $2, $3) RETURNING "prim"`, statements[0].SQL)
+}
+
+func TestDryRunRawQuery(t *testing.T) {
+	assert := NewAssert(t)
+	q, _ := NewDryRunDB(NewPostgres()).GetQbs()
+	defer q.Close()
+	_, err := q.QueryMap("SELECT * FROM basic WHERE id = ?", 1)
+	assert.Equal(sql.ErrNoRows, err)
+	var datas []*basic
+	err = q.QueryStruct(&datas, "SELECT * FROM basic WHERE state > ?", 2)
+	assert.MustNil(err)
+	statements := q.Statements()
+	assert.MustEqual(2, len(statements))
+	assert.Equal("SELECT * FROM basic WHERE id = $1", statements[0].SQL)
+	assert.Equal("[1]", statements[0].Args)
+	assert.Equal("SELECT * FROM basic WHERE state > $1", statements[1].SQL)
+}
+
+func TestDryRunUpdateColumns(t *testing.T) {
+	assert := NewAssert(t)
+	type Article struct {
+		Id      int64
+		Title   string
+		Updated time.Time
+	}
+	q, _ := NewDryRunDB(NewMysql()).GetQbs()
+	defer q.Close()
+	_, err := q.UpdateColumns(&Article{Id: 2}, map[string]interface{}{"title": "a"})
+	assert.MustNil(err)
+	_, err = q.WhereEqual("title", "a").UpdateColumns("article", map[string]interface{}{"title": "b"})
+	assert.MustNil(err)
+	statements := q.Statements()
+	assert.MustEqual(2, len(statements))
+	assert.Equal("UPDATE `article` SET `title` = ?, `updated` = ? WHERE `id` = ?", statements[0].SQL)
+	assert.Equal(3, len(statements[0].Args))
+	assert.Equal("UPDATE `article` SET `title` = ? WHERE title = ?", statements[1].SQL)
+}
+
+func TestDryRunOptimisticLocking(t *testing.T) {
+	assert := NewAssert(t)
+	q, _ := NewDryRunDB(NewSqlite3()).GetQbs()
+	defer q.Close()
+	_, err := q.Update(&versionedTable{Id: 1, Name: "a", Version: 3})
+	assert.Nil(err)
+	statements := q.Statements()
+	assert.MustEqual(1, len(statements))
+	assert.Equal("UPDATE `versioned_table` SET `name` = ?, `version` = ? WHERE (`id` = ?) AND (`version` = ?)", statements[0].SQL)
+	assert.Equal("[a 4 1 3]", statements[0].Args)
+	query, args, err := q.ToSQL(OpUpdate, &versionedTable{Id: 1, Name: "a", Version: 3})
+	assert.MustNil(err)
+	assert.Equal(statements[0].SQL, query)
+	assert.Equal("[a 4 1 3]", args)
+	query, args, err = q.ToSQL(OpSave, &versionedTable{Id: 1, Name: "a", Version: 3})
+	assert.MustNil(err)
+	assert.Equal("UPDATE `versioned_table` SET `name` = ?, `version` = ? WHERE (id = ?) AND (`version` = ?)", query)
+	assert.Equal("[a 4 1 3]", args)
+}
+
+func TestDryRunSoftDelete(t *testing.T) {
+	assert := NewAssert(t)
+	q, _ := NewDryRunDB(NewPostgres()).GetQbs()
+	defer q.Close()
+	query, _, err := q.ToSQL(OpFind, &softDeleteTable{Id: 1})
+	assert.MustNil(err)
+	assert.Equal(`SELECT "id", "name", "deleted" FROM "soft_delete_table" WHERE ("soft_delete_table"."id" = $1) AND ("soft_delete_table"."deleted" IS NULL) LIMIT $2`, query)
+	var models []*softDeleteTable
+	query, _, err = q.OnlyDeleted().ToSQL(OpFindAll, &models)
+	assert.MustNil(err)
+	assert.Equal(`SELECT "id", "name", "deleted" FROM "soft_delete_table" WHERE "soft_delete_table"."deleted" IS NOT NULL`, query)
+	query, _, err = q.WithDeleted().ToSQL(OpFindAll, &models)
+	assert.MustNil(err)
+	assert.Equal(`SELECT "id", "name", "deleted" FROM "soft_delete_table"`, query)
+	query, args, err := q.ToSQL(OpDelete, &softDeleteTable{Id: 1})
+	assert.MustNil(err)
+	assert.Equal(`UPDATE "soft_delete_table" SET "deleted" = $1 WHERE ("id" = $2) AND ("soft_delete_table"."deleted" IS NULL)`, query)
+	assert.Equal(2, len(args))
+
+	model := &softDeleteTable{Id: 1}
+	_, err = q.Restore(model)
+	assert.MustNil(err)
+	_, err = q.HardDelete(model)
+	assert.MustNil(err)
+	statements := q.Statements()
+	assert.MustEqual(2, len(statements))
+	assert.Equal(`UPDATE "soft_delete_table" SET "deleted" = NULL WHERE ("id" = $1) AND ("soft_delete_table"."deleted" IS NOT NULL)`, statements[0].SQL)
+	assert.Equal(`DELETE FROM "soft_delete_table" WHERE "id" = $1`, statements[1].SQL)
+}
+
+func TestDryRunJoinDepth(t *testing.T) {
+	assert := NewAssert(t)
+	type User struct {
+		Id   int64
+		Name string
+	}
+	type Post struct {
+		Id       int64
+		AuthorId int64
+		Author   *User
+	}
+	type Comment struct {
+		Id     int64
+		PostId int64
+		Post   *Post
+	}
+	q, _ := NewDryRunDB(NewPostgres()).GetQbs()
+	defer q.Close()
+	var comments []*Comment
+	query, _, err := q.ToSQL(OpFindAll, &comments)
+	assert.MustNil(err)
+	assert.Equal(`SELECT "comment"."id", "comment"."post_id", "post"."id" AS post___id, "post"."author_id" AS post___author_id `+
+		`FROM "comment" LEFT JOIN "post" AS "post" ON "comment"."post_id" = "post"."id"`, query)
+	query, _, err = q.JoinDepth(2).Select("Id", "Post.Author.Name").ToSQL(OpFindAll, &comments)
+	assert.MustNil(err)
+	assert.Equal(`SELECT "comment"."id", "post___author"."name" AS post___author___name FROM "comment" `+
+		`LEFT JOIN "post" AS "post" ON "comment"."post_id" = "post"."id" `+
+		`LEFT JOIN "user" AS "post___author" ON "post"."author_id" = "post___author"."id"`, query)
+}
+
+func TestDryRunMultipleRefs(t *testing.T) {
+	assert := NewAssert(t)
+	q, _ := NewDryRunDB(NewPostgres()).GetQbs()
+	defer q.Close()
+	var tasks []*refTask
+	query, _, err := q.Select("Title", "Creator.Name", "Reviewer.Name").ToSQL(OpFindAll, &tasks)
+	assert.MustNil(err)
+	assert.Equal(`SELECT "ref_task"."title", "creator"."name" AS creator___name, "reviewer"."name" AS reviewer___name `+
+		`FROM "ref_task" LEFT JOIN "ref_user" AS "creator" ON "ref_task"."creator_id" = "creator"."id" `+
+		`LEFT JOIN "ref_user" AS "reviewer" ON "ref_task"."reviewed_by_id" = "reviewer"."id"`, query)
+	sql := NewPostgres().createTableSql(structPtrToModel(new(refTask), true, nil), false)
+	assert.Equal(`CREATE TABLE "ref_task" ( "id" bigserial PRIMARY KEY, "title" text, "creator_id" bigint, `+
+		`"assignee_id" bigint, "reviewed_by_id" bigint, `+
+		`FOREIGN KEY ("assignee_id") REFERENCES "ref_user" ("id") ON DELETE CASCADE, `+
+		`FOREIGN KEY ("creator_id") REFERENCES "ref_user" ("id") ON DELETE CASCADE, `+
+		`FOREIGN KEY ("reviewed_by_id") REFERENCES "ref_user" ("id") ON DELETE CASCADE )`, sql)
+	sql = NewPostgres().createTableSql(structPtrToModel(new(refCategory), true, nil), false)
+	assert.Equal(`CREATE TABLE "ref_category" ( "id" bigserial PRIMARY KEY, "name" text, "parent_id" bigint, `+
+		`FOREIGN KEY ("parent_id") REFERENCES "ref_category" ("id") ON DELETE CASCADE )`, sql)
+}
+
+func TestDryRunClose(t *testing.T) {
+	assert := NewAssert(t)
+	d1, d2 := NewDryRunDB(NewSqlite3()), NewDryRunDB(NewSqlite3())
+	assert.Nil(d1.Close())
+	q, err := d2.GetQbs()
+	assert.MustNil(err)
+	defer q.Close()
+	_, err = q.Insert(&sqlGenModel{First: "a"})
+	assert.Nil(err)
+	assert.Equal(1, len(q.Statements()))
+}
@@ -0,0 +1,115 @@
+package qbs
+
+import (
+	"reflect"
+)
+
+// The hooks are optional interfaces the struct can implement besides Validator.
+// The Before hooks are called after the validation, an error returned by a Before hook aborts the operation.
+// The After hooks are called after the operation succeeds, the error returned is returned by the operation.
+// In a transaction, the errors returned by the hooks count toward the first error reported by Commit.
+// The hooks are called with a reset criteria, so they can perform other operations on the Qbs.
+
+// BeforeInserter.BeforeInsert is called by Save, Insert and BulkInsert before the struct is inserted,
+// so it can set the fields to be inserted.
+type BeforeInserter interface {
+	BeforeInsert(*Qbs) error
+}
+
+// AfterInserter.AfterInsert is called by Save, Insert and BulkInsert after the struct is inserted, the id has been filled in.
+type AfterInserter interface {
+	AfterInsert(*Qbs) error
+}
+
+// BeforeUpdater.BeforeUpdate is called by Save and Update before the struct is updated, so it can set the fields to be updated.
+type BeforeUpdater interface {
+	BeforeUpdate(*Qbs) error
+}
+
+// AfterUpdater.AfterUpdate is called by Save and Update after the struct is updated.
+type AfterUpdater interface {
+	AfterUpdate(*Qbs) error
+}
+
+// BeforeDeleter.BeforeDelete is called by Delete and HardDelete before the rows are deleted.
+type BeforeDeleter interface {
+	BeforeDelete(*Qbs) error
+}
+
+// AfterDeleter.AfterDelete is called by Delete and HardDelete after the rows are deleted.
+type AfterDeleter interface {
+	AfterDelete(*Qbs) error
+}
+
+// AfterFinder.AfterFind is called by Find, FindAll and Iterate on each struct after it's loaded.
+type AfterFinder interface {
+	AfterFind(*Qbs) error
+}
+
+// callHook calls the hook with a reset criteria, the criteria of the operation is restored afterwards.
+func (q *Qbs) callHook(hook func(*Qbs) error) error {
+	c := q.criteria
+	q.criteria = new(criteria)
+	err := hook(q)
+	q.criteria = c
+	return q.updateTxError(err)
+}
+
+func (q *Qbs) beforeInsert(structPtr interface{}) error {
+	if h, ok := structPtr.(BeforeInserter); ok {
+		return q.callHook(h.BeforeInsert)
+	}
+	return nil
+}
+
+func (q *Qbs) afterInsert(structPtr interface{}) error {
+	if h, ok := structPtr.(AfterInserter); ok {
+		return q.callHook(h.AfterInsert)
+	}
+	return nil
+}
+
+func (q *Qbs) beforeUpdate(structPtr interface{}) error {
+	if h, ok := structPtr.(BeforeUpdater); ok {
+		return q.callHook(h.BeforeUpdate)
+	}
+	return nil
+}
+
+func (q *Qbs) afterUpdate(structPtr interface{}) error {
+	if h, ok := structPtr.(AfterUpdater); ok {
+		return q.callHook(h.AfterUpdate)
+	}
+	return nil
+}
+
+func (q *Qbs) beforeDelete(structPtr interface{}) error {
+	if h, ok := structPtr.(BeforeDeleter); ok {
+		return q.callHook(h.BeforeDelete)
+	}
+	return nil
+}
+
+func (q *Qbs) afterDelete(structPtr interface{}) error {
+	if h, ok := structPtr.(AfterDeleter); ok {
+		return q.callHook(h.AfterDelete)
+	}
+	return nil
+}
+
+func (q *Qbs) afterFind(structPtr interface{}) error {
+	if h, ok := structPtr.(AfterFinder); ok {
+		return q.callHook(h.AfterFind)
+	}
+	return nil
+}
+
+// afterFindAll calls the AfterFind hook on the structs appended to the slice from index start.
+func (q *Qbs) afterFindAll(sliceValue reflect.Value, start int) error {
+	for i := start; i < sliceValue.Len(); i++ {
+		if err := q.afterFind(sliceValue.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
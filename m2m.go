@@ -0,0 +1,220 @@
+package qbs
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// manyToMany describes the join table of a many-to-many field like `Tags []*Tag qbs:"m2m:post_tag"` of Post,
+// the join table has a column for each side named after the struct, like post_id and tag_id.
+type manyToMany struct {
+	table        string
+	parentColumn string
+	childColumn  string
+}
+
+func newManyToMany(parentType reflect.Type, field reflect.StructField, table string) *manyToMany {
+	childType := field.Type.Elem().Elem()
+	m := &manyToMany{
+		table:        table,
+		parentColumn: FieldNameToColumnName(parentType.Name() + "Id"),
+		childColumn:  FieldNameToColumnName(childType.Name() + "Id"),
+	}
+	if m.childColumn == m.parentColumn {
+		// self reference like `Friends []*User qbs:"m2m:friendship"`, the child column is named after the field.
+		m.childColumn = FieldNameToColumnName(field.Name + "Id")
+	}
+	return m
+}
+
+// manyToManyFields returns the many-to-many fields of the struct type and their join tables.
+func manyToManyFields(structType reflect.Type) ([]reflect.StructField, []*manyToMany) {
+	var fields []reflect.StructField
+	var joins []*manyToMany
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		sqlTag := field.Tag.Get("qbs")
+		if sqlTag == "" || sqlTag == "-" || !isAssociationField(field) {
+			continue
+		}
+		fd := new(modelField)
+		parseTags(fd, sqlTag)
+		if fd.m2m != "" {
+			fields = append(fields, field)
+			joins = append(joins, newManyToMany(structType, field, fd.m2m))
+		}
+	}
+	return fields, joins
+}
+
+// model builds the model of the join table, which references both tables with foreign keys,
+// and has an unique index on both columns.
+func (m *manyToMany) model(parent, child *model) *model {
+	parentField := &modelField{name: m.parentColumn, value: parent.pk.value, size: parent.pk.size, notnull: true}
+	childField := &modelField{name: m.childColumn, value: child.pk.value, size: child.pk.size, notnull: true}
+	joinModel := &model{
+		table:  m.table,
+		fields: []*modelField{parentField, childField},
+		refs: map[string]*reference{
+			"Parent": {refKey: m.parentColumn, model: parent, foreignKey: true},
+			"Child":  {refKey: m.childColumn, model: child, foreignKey: true},
+		},
+		indexes: Indexes{},
+	}
+	joinModel.indexes.AddUnique(m.parentColumn, m.childColumn)
+	joinModel.indexes.Add(m.childColumn)
+	return joinModel
+}
+
+func (q *Qbs) preloadManyToMany(byKey map[interface{}][]reflect.Value, keys []interface{},
+	field reflect.StructField, m *manyToMany) error {
+	parentColumn := q.Dialect.quote(m.parentColumn)
+	childColumn := q.Dialect.quote(m.childColumn)
+	// the keys are split like the in condition of FindAll, so the query stays under the placeholder limit.
+	con := NewInCondition(parentColumn, keys)
+	chunks := (&criteria{condition: con}).inChunks(q.Dialect)
+	if chunks == nil {
+		chunks = [][]interface{}{con.args}
+	}
+	var pairs [][2]interface{}
+	var childKeys []interface{}
+	seen := make(map[interface{}]bool)
+	for _, chunk := range chunks {
+		con.args = chunk
+		conditionSql, args := con.merge(q.Dialect)
+		query := fmt.Sprintf("SELECT %v, %v FROM %v WHERE %v", parentColumn, childColumn, q.Dialect.quote(m.table), conditionSql)
+		chunkPairs, err := q.joinPairs(query, args)
+		if err != nil {
+			return err
+		}
+		for _, pair := range chunkPairs {
+			pairs = append(pairs, pair)
+			if !seen[pair[1]] {
+				seen[pair[1]] = true
+				childKeys = append(childKeys, pair[1])
+			}
+		}
+	}
+	if len(childKeys) == 0 {
+		return nil
+	}
+
+	children := reflect.New(field.Type)
+	childPk := structPtrToModel(reflect.New(field.Type.Elem().Elem()).Interface(), false, nil).pk
+	if childPk == nil {
+		panic("no primary key field")
+	}
+	childTable := tableName(children.Interface())
+	column := q.Dialect.quote(childTable) + "." + q.Dialect.quote(childPk.name)
+	if err := q.Condition(NewInCondition(column, childKeys)).FindAll(children.Interface()); err != nil {
+		return err
+	}
+	children = children.Elem()
+	childByKey := make(map[interface{}]reflect.Value, children.Len())
+	for i := 0; i < children.Len(); i++ {
+		child := children.Index(i)
+		childByKey[preloadKey(child.Elem().FieldByName(childPk.camelName))] = child
+	}
+	for _, pair := range pairs {
+		if child, ok := childByKey[pair[1]]; ok {
+			appendChild(byKey[pair[0]], field, child)
+		}
+	}
+	return nil
+}
+
+// joinPairs queries the parent and child keys from the join table.
+func (q *Qbs) joinPairs(query string, args []interface{}) ([][2]interface{}, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var pairs [][2]interface{}
+	for rows.Next() {
+		var parentKey, childKey interface{}
+		if err = rows.Scan(&parentKey, &childKey); err != nil {
+			return nil, q.updateTxError(err)
+		}
+		pairs = append(pairs, [2]interface{}{scannedKey(parentKey), scannedKey(childKey)})
+	}
+	if err = rows.Err(); err != nil {
+		return nil, q.updateTxError(err)
+	}
+	return pairs, nil
+}
+
+// scannedKey converts the key scanned from the join table to a map key which matches preloadKey.
+func scannedKey(v interface{}) interface{} {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return preloadKey(reflect.ValueOf(v))
+}
+
+// Associate inserts a row into the join table of the many-to-many field of the struct, whose element type
+// is the type of the associated struct, like q.Associate(post, tag) for `Tags []*Tag qbs:"m2m:post_tag"` of Post.
+// Both structs should have been saved, it does nothing if they are already associated.
+// It panics if the struct has more than one many-to-many field of the associated type.
+func (q *Qbs) Associate(structPtr, associatedPtr interface{}) (affected int64, err error) {
+	defer q.Reset()
+	m, parentKey, childKey, err := manyToManyOf(structPtr, associatedPtr)
+	if err != nil {
+		return 0, err
+	}
+	condition := q.Dialect.quote(m.parentColumn) + " = ? AND " + q.Dialect.quote(m.childColumn) + " = ?"
+	if q.Where(condition, parentKey, childKey).Count(m.table) > 0 {
+		return 0, nil
+	}
+	query := fmt.Sprintf("INSERT INTO %v (%v, %v) VALUES (?, ?)",
+		q.Dialect.quote(m.table), q.Dialect.quote(m.parentColumn), q.Dialect.quote(m.childColumn))
+	result, err := q.Exec(query, parentKey, childKey)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Dissociate deletes the row of the structs from the join table, it's the reverse of Associate.
+func (q *Qbs) Dissociate(structPtr, associatedPtr interface{}) (affected int64, err error) {
+	defer q.Reset()
+	m, parentKey, childKey, err := manyToManyOf(structPtr, associatedPtr)
+	if err != nil {
+		return 0, err
+	}
+	query := fmt.Sprintf("DELETE FROM %v WHERE %v = ? AND %v = ?",
+		q.Dialect.quote(m.table), q.Dialect.quote(m.parentColumn), q.Dialect.quote(m.childColumn))
+	result, err := q.Exec(query, parentKey, childKey)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// manyToManyOf finds the many-to-many field of the struct for the associated struct,
+// and returns the join table and the primary key values of both structs.
+func manyToManyOf(structPtr, associatedPtr interface{}) (m *manyToMany, parentKey, childKey interface{}, err error) {
+	childType := reflect.TypeOf(associatedPtr)
+	fields, joins := manyToManyFields(reflect.TypeOf(structPtr).Elem())
+	for i, field := range fields {
+		if field.Type.Elem() == childType {
+			if m != nil {
+				panic("More than one many-to-many field of " + childType.Elem().Name())
+			}
+			m = joins[i]
+		}
+	}
+	if m == nil {
+		panic("Can not find many-to-many field of " + childType.Elem().Name())
+	}
+	parent := structPtrToModel(structPtr, false, nil)
+	child := structPtrToModel(associatedPtr, false, nil)
+	if parent.pk == nil || child.pk == nil {
+		panic("no primary key field")
+	}
+	if parent.pkZero() || child.pkZero() {
+		return nil, nil, nil, errors.New("the associated structs should be saved first")
+	}
+	return m, parent.pk.value, child.pk.value, nil
+}
@@ -3,6 +3,7 @@ package qbs
 import (
 	"database/sql"
 	"fmt"
+	"reflect"
 	"strings"
 )
 
@@ -11,12 +12,67 @@ type Migration struct {
 	dbName  string
 	dialect Dialect
 	Log     bool
+	shared  bool // the *sql.DB is owned by the DB, it's not closed by Close.
+	pending []pendingJoin
+}
+
+// pendingJoin is the join table of a created table, which waits for the table of the other side to be created.
+type pendingJoin struct {
+	parent     *model
+	childTable string
+	join       *manyToMany
 }
 
 // CreateTableIfNotExists creates a new table and its indexes based on the table struct type
 // It will panic if table creation failed, and it will return error if the index creation failed.
+// The join tables of the many-to-many fields are created if the tables of both sides exist,
+// otherwise the join table is created along with the other table if it's created later by the same Migration,
+// see CreateJoinTableIfNotExists for tables created by different Migrations.
 func (mg *Migration) CreateTableIfNotExists(structPtr interface{}) error {
 	model := structPtrToModel(structPtr, true, nil)
+	err := mg.createTable(model)
+	fields, joins := manyToManyFields(reflect.TypeOf(structPtr).Elem())
+	for i, m := range joins {
+		child := structPtrToModel(reflect.New(fields[i].Type.Elem().Elem()).Interface(), false, nil)
+		if len(mg.dialect.columnsInTable(mg, child.table)) == 0 {
+			mg.pending = append(mg.pending, pendingJoin{model, child.table, m})
+			continue
+		}
+		if joinErr := mg.createTable(m.model(model, child)); joinErr != nil {
+			err = joinErr
+		}
+	}
+	var pending []pendingJoin
+	for _, p := range mg.pending {
+		if p.childTable != model.table {
+			pending = append(pending, p)
+			continue
+		}
+		if joinErr := mg.createTable(p.join.model(p.parent, model)); joinErr != nil {
+			err = joinErr
+		}
+	}
+	mg.pending = pending
+	return err
+}
+
+// CreateJoinTableIfNotExists creates the join table of the many-to-many field of the struct, like
+// mg.CreateJoinTableIfNotExists(new(Post), "Tags") for `Tags []*Tag qbs:"m2m:post_tag"` of Post.
+// The tables of both sides should have been created, it's only needed if they are created by different Migrations,
+// as CreateTableIfNotExists creates the join table along with the latter one.
+func (mg *Migration) CreateJoinTableIfNotExists(structPtr interface{}, fieldName string) error {
+	fields, joins := manyToManyFields(reflect.TypeOf(structPtr).Elem())
+	for i, field := range fields {
+		if field.Name == fieldName {
+			parent := structPtrToModel(structPtr, true, nil)
+			child := structPtrToModel(reflect.New(field.Type.Elem().Elem()).Interface(), false, nil)
+			return mg.createTable(joins[i].model(parent, child))
+		}
+	}
+	panic("Can not find many-to-many field " + fieldName)
+}
+
+func (mg *Migration) createTable(model *model) error {
 	sql := mg.dialect.createTableSql(model, true)
 	if mg.Log {
 		fmt.Println(sql)
@@ -101,7 +157,7 @@ func (mg *Migration) CreateIndexIfNotExists(table interface{}, name string, uniq
 }
 
 func (mg *Migration) Close() {
-	if mg.db != nil {
+	if mg.db != nil && !mg.shared {
 		err := mg.db.Close()
 		if err != nil {
 			panic(err)
@@ -111,22 +167,10 @@ func (mg *Migration) Close() {
 
 // Get a Migration instance should get closed like Qbs instance.
 func GetMigration() (mg *Migration, err error) {
-	if driver == "" || dial == nil {
-		panic("database driver has not been registered, should call Register first.")
-	}
-	db, err := sql.Open(driver, driverSource)
-	if err != nil {
-		return nil, err
-	}
-	return &Migration{db, dbName, dial, false}, nil
+	return defaultDB.GetMigration()
 }
 
 // A safe and easy way to work with Migration instance without the need to open and close it.
 func WithMigration(task func(mg *Migration) error) error {
-	mg, err := GetMigration()
-	if err != nil {
-		return err
-	}
-	defer mg.Close()
-	return task(mg)
+	return defaultDB.WithMigration(task)
 }
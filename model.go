@@ -69,21 +69,28 @@ type modelField struct {
 	unique    bool
 	updated   bool
 	created   bool
+	version   bool
+	deleted   bool
 	size      int
 	dfault    string
 	fk        string
 	join      string
+	m2m       string // join table of a many-to-many slice field
 	colType   string
+	expr      string
 	nullable  reflect.Kind
 }
 
 // Model represents a parsed schema interface{}.
 type model struct {
-	pk      *modelField
-	table   string
-	fields  []*modelField
-	refs    map[string]*reference
-	indexes Indexes
+	pk         *modelField
+	version    *modelField // for optimistic locking
+	deleted    *modelField // for soft delete
+	table      string
+	fields     []*modelField
+	exprFields []*modelField // selected by expression, not a column of the table
+	refs       map[string]*reference
+	indexes    Indexes
 }
 
 type reference struct {
@@ -97,7 +104,11 @@ func (model *model) columnsAndValues(forUpdate bool) ([]string, []interface{}) {
 	values := make([]interface{}, 0, len(columns))
 	for _, column := range model.fields {
 		var include bool
-		if forUpdate {
+		if column.deleted {
+			// the deleted time is only set by Delete and Restore, or inserted if it's not zero.
+			t, _ := column.value.(time.Time)
+			include = !forUpdate && !t.IsZero()
+		} else if forUpdate {
 			include = column.value != nil && !column.pk
 		} else {
 			include = true
@@ -167,6 +178,17 @@ func (model *model) pkZero() bool {
 }
 
 func structPtrToModel(f interface{}, root bool, omitFields []string) *model {
+	depth := 0
+	if root {
+		depth = 1
+	}
+	return structPtrToModelDepth(f, root, depth, omitFields)
+}
+
+// structPtrToModelDepth parses the struct, the references are filled in up to depth levels,
+// so the referenced structs of the referenced structs can be joined.
+// The indexes are filled in only in root model.
+func structPtrToModelDepth(f interface{}, root bool, depth int, omitFields []string) *model {
 	model := &model{
 		pk:      nil,
 		table:   tableName(f),
@@ -200,6 +222,7 @@ func structPtrToModel(f interface{}, root bool, omitFields []string) *model {
 			continue
 		}
 		fieldIsNullable := false
+		timePtr := false
 		kind := structField.Type.Kind()
 		switch kind {
 		case reflect.Ptr:
@@ -208,7 +231,10 @@ func structPtrToModel(f interface{}, root bool, omitFields []string) *model {
 				kind = structField.Type.Elem().Kind()
 				fieldIsNullable = true
 			default:
-				continue
+				if structField.Type.Elem() != reflect.TypeOf(time.Time{}) {
+					continue
+				}
+				timePtr = true
 			}
 		case reflect.Map:
 			continue
@@ -221,9 +247,22 @@ func structPtrToModel(f interface{}, root bool, omitFields []string) *model {
 
 		fd := new(modelField)
 		parseTags(fd, sqlTag)
+		if timePtr && !fd.deleted {
+			continue
+		}
 		fd.camelName = structField.Name
 		fd.name = FieldNameToColumnName(structField.Name)
-		if fieldIsNullable {
+		if fd.expr != "" {
+			model.exprFields = append(model.exprFields, fd)
+			continue
+		}
+		if timePtr {
+			// a nil deleted time is treated as zero time, which is not deleted.
+			fd.value = time.Time{}
+			if !fieldValue.IsNil() {
+				fd.value = fieldValue.Elem().Interface()
+			}
+		} else if fieldIsNullable {
 			fd.nullable = kind
 			if fieldValue.IsNil() {
 				fd.value = nil
@@ -240,10 +279,21 @@ func structPtrToModel(f interface{}, root bool, omitFields []string) *model {
 		if fd.pk {
 			model.pk = fd
 		}
+		if fd.version {
+			if fieldIsNullable || kind < reflect.Int || kind > reflect.Int64 {
+				panic("version field should be a non-pointer integer")
+			}
+			model.version = fd
+		}
+		if fd.deleted {
+			if _, ok := fd.value.(time.Time); !ok {
+				panic("deleted field should be a time.Time or *time.Time")
+			}
+			model.deleted = fd
+		}
 
 		model.fields = append(model.fields, fd)
-		// fill in references map only in root model.
-		if root {
+		if depth > 0 {
 			var fk, explicitJoin, implicitJoin bool
 			var refName string
 			if fd.fk != "" {
@@ -254,7 +304,8 @@ func structPtrToModel(f interface{}, root bool, omitFields []string) *model {
 				explicitJoin = true
 			}
 
-			if len(fd.camelName) > 3 && strings.HasSuffix(fd.camelName, "Id") {
+			// the implicit join by the XxxId field name applies only if the referenced field is not specified.
+			if !fk && !explicitJoin && len(fd.camelName) > 3 && strings.HasSuffix(fd.camelName, "Id") {
 				fdValue := reflect.ValueOf(fd.value)
 				if _, ok := fd.value.(sql.NullInt64); ok || fdValue.Kind() == reflect.Int64 {
 					i := strings.LastIndex(fd.camelName, "Id")
@@ -273,11 +324,13 @@ func structPtrToModel(f interface{}, root bool, omitFields []string) *model {
 				if field, ok := structType.FieldByName(refName); ok && !omit {
 					fieldValue := structValue.FieldByName(refName)
 					if fieldValue.Kind() == reflect.Ptr {
-						model.indexes.Add(fd.name)
+						if root {
+							model.indexes.Add(fd.name)
+						}
 						if fieldValue.IsNil() {
 							fieldValue.Set(reflect.New(field.Type.Elem()))
 						}
-						refModel := structPtrToModel(fieldValue.Interface(), false, nil)
+						refModel := structPtrToModelDepth(fieldValue.Interface(), false, depth-1, nil)
 						ref := new(reference)
 						ref.foreignKey = fk
 						ref.model = refModel
@@ -293,6 +346,8 @@ func structPtrToModel(f interface{}, root bool, omitFields []string) *model {
 					panic("Can not find referenced field")
 				}
 			}
+		}
+		if root {
 			if fd.unique {
 				model.indexes.AddUnique(fd.name)
 			} else if fd.index {
@@ -331,6 +386,11 @@ func tableName(talbe interface{}) string {
 }
 
 func parseTags(fd *modelField, s string) {
+	// expression may contain comma and colon, so it must be the last one in the tag.
+	if i := strings.Index(s, "expr:"); i == 0 || i > 0 && s[i-1] == ',' {
+		fd.expr = s[i+len("expr:"):]
+		s = strings.TrimSuffix(s[:i], ",")
+	}
 	if s == "" {
 		return
 	}
@@ -349,6 +409,8 @@ func parseTags(fd *modelField, s string) {
 				fd.join = c2[1]
 			case "coltype":
 				fd.colType = c2[1]
+			case "m2m":
+				fd.m2m = c2[1]
 			default:
 				panic(c2[0] + " tag syntax error")
 			}
@@ -360,6 +422,10 @@ func parseTags(fd *modelField, s string) {
 				fd.pk = true
 			case "updated":
 				fd.updated = true
+			case "version":
+				fd.version = true
+			case "deleted":
+				fd.deleted = true
 			case "index":
 				fd.index = true
 			case "unique":
@@ -420,5 +486,9 @@ var ValidTags = map[string]bool{
 	"notnull": true,
 	"updated": true,
 	"created": true,
+	"version": true, //optimistic locking
+	"deleted": true, //soft delete
 	"coltype": true,
+	"m2m":     true, //many-to-many join table, like `qbs:"m2m:post_tag"`
+	"expr":    true, //select expression, like `qbs:"expr:COUNT(*)"`
 }
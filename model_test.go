@@ -15,6 +15,16 @@ func TestParseTags(t *testing.T) {
 	parseTags(fd, `notnull,default:'banana'`)
 	assert.True(fd.notnull)
 	assert.Equal("'banana'", fd.dfault)
+	fd = new(modelField)
+	parseTags(fd, `size:64,expr:COALESCE(a, b)`)
+	assert.Equal(64, fd.size)
+	assert.Equal("COALESCE(a, b)", fd.expr)
+	fd = new(modelField)
+	parseTags(fd, `version,notnull`)
+	assert.True(fd.version)
+	fd = new(modelField)
+	parseTags(fd, `deleted`)
+	assert.True(fd.deleted)
 }
 
 func TestFieldOmit(t *testing.T) {
@@ -166,3 +176,29 @@ func TestModelFieldsIndicateNullable(t *testing.T) {
 		}
 	}
 }
+
+func TestManyToManyOf(t *testing.T) {
+	assert := NewAssert(t)
+	type Tag struct {
+		Id   int64
+		Name string
+	}
+	type Post struct {
+		Id     int64
+		Tags   []*Tag `qbs:"m2m:post_tag"`
+		Labels []*Tag `qbs:"m2m:post_label"`
+	}
+	type Article struct {
+		Id   int64
+		Tags []*Tag `qbs:"m2m:article_tag"`
+	}
+	m, parentKey, childKey, err := manyToManyOf(&Article{Id: 1}, &Tag{Id: 2})
+	assert.MustNil(err)
+	assert.Equal("article_tag", m.table)
+	assert.Equal(1, parentKey)
+	assert.Equal(2, childKey)
+	defer func() {
+		assert.Equal("More than one many-to-many field of Tag", recover())
+	}()
+	manyToManyOf(&Post{Id: 1}, &Tag{Id: 2})
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"reflect"
+	"strings"
 	"time"
 )
 
@@ -63,6 +64,10 @@ func (d mysql) sqlType(field modelField) string {
 	case reflect.Struct:
 		switch fieldValue.Interface().(type) {
 		case time.Time:
+			if field.deleted {
+				// timestamp columns are not null by default in older versions.
+				return "timestamp NULL"
+			}
 			return "timestamp"
 		case sql.NullBool:
 			return "boolean"
@@ -109,3 +114,40 @@ func (d mysql) primaryKeySql(isString bool, size int) string {
 	}
 	return "bigint PRIMARY KEY AUTO_INCREMENT"
 }
+
+// Error 1213 is deadlock, error 1205 is lock wait timeout.
+func (d mysql) isRetryableError(err error) bool {
+	errString := err.Error()
+	return strings.Contains(errString, "Error 1213") || strings.Contains(errString, "Error 1205")
+}
+
+func (d mysql) isDuplicateKeyError(err error) bool {
+	return strings.Contains(err.Error(), "Error 1062")
+}
+
+func (d mysql) txOptions(opts sql.TxOptions) sql.TxOptions {
+	switch opts.Isolation {
+	case sql.LevelWriteCommitted, sql.LevelSnapshot:
+		opts.Isolation = sql.LevelRepeatableRead
+	case sql.LevelLinearizable:
+		opts.Isolation = sql.LevelSerializable
+	}
+	return opts
+}
+
+// The primary key is assigned to LAST_INSERT_ID, so the last insert id is the id of the row even if it's updated.
+func (d mysql) upsertSql(criteria *criteria, conflictColumns []string) (string, []interface{}) {
+	sql, values := d.base.insertSql(criteria)
+	pairs := []string{}
+	for _, c := range upsertUpdateColumns(criteria.model, conflictColumns) {
+		pairs = append(pairs, d.quote(c)+" = VALUES("+d.quote(c)+")")
+	}
+	pk := d.quote(criteria.model.pk.name)
+	if _, ok := criteria.model.pk.value.(int64); ok {
+		pairs = append(pairs, pk+" = LAST_INSERT_ID("+pk+")")
+	} else if len(pairs) == 0 {
+		pairs = append(pairs, pk+" = "+pk)
+	}
+	sql += " ON DUPLICATE KEY UPDATE " + strings.Join(pairs, ", ")
+	return sql, values
+}
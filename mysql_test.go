@@ -1,6 +1,7 @@
 package qbs
 
 import (
+	"errors"
 	_ "github.com/coocood/mysql"
 	"testing"
 )
@@ -18,6 +19,13 @@ var mysqlSyntax = dialectSyntax{
 	"ALTER TABLE `a` ADD COLUMN `newc` varchar(100)",
 	"CREATE UNIQUE INDEX `iname` ON `itable` (`a`, `b`, `c`)",
 	"CREATE INDEX `iname2` ON `itable2` (`d`, `e`)",
+	"SELECT `grade`, COUNT(*) AS `total` FROM `student` WHERE score >= ? GROUP BY `grade` HAVING COUNT(*) > ? ORDER BY `grade`",
+	"SELECT DISTINCT `post`.`author_id`, `author`.`name` AS author___name FROM `post` LEFT JOIN `user` AS `author` ON `post`.`author_id` = `author`.`id`",
+	"SELECT `name`, `grade`, `score` FROM `student` WHERE ((`grade` BETWEEN ? AND ?) AND (NOT (`score` NOT IN (?, ?)))) OR (LOWER(`name`) LIKE LOWER(?))",
+	"SELECT `name`, `grade`, `score` FROM `student` WHERE ((`grade` = ?) AND (`name` IN (SELECT `name` FROM `student` WHERE `score` > ? LIMIT ?))) OR (`score` > (SELECT `name` FROM `student` WHERE `score` > ? LIMIT ?)) LIMIT ?",
+	"INSERT INTO `bulk_model` (`name`) VALUES (?), (?)",
+	"INSERT INTO `upsert_model` (`code`, `amount`, `created`) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE `amount` = VALUES(`amount`), `id` = LAST_INSERT_ID(`id`)",
+	"UPDATE `counter` SET `clicks` = `clicks` - ?, `updated` = CURRENT_TIMESTAMP, `views` = `views` + ? WHERE `id` = ?",
 }
 
 func setupMysqlDb() (*Migration, *Qbs) {
@@ -81,6 +89,21 @@ func TestMysqlTransaction(t *testing.T) {
 	doTestTransaction(NewAssert(t))
 }
 
+func TestMysqlNestedTransaction(t *testing.T) {
+	registerMysqlTest()
+	doTestNestedTransaction(NewAssert(t))
+}
+
+func TestMysqlWithTransaction(t *testing.T) {
+	registerMysqlTest()
+	doTestWithTransaction(NewAssert(t), errors.New("Error 1213: Deadlock found when trying to get lock"))
+}
+
+func TestMysqlBeginWith(t *testing.T) {
+	registerMysqlTest()
+	doTestBeginWith(NewAssert(t))
+}
+
 func TestMysqlSaveAndDelete(t *testing.T) {
 	mg, q := setupMysqlDb()
 	doTestSaveAndDelete(NewAssert(t), mg, q)
@@ -111,6 +134,56 @@ func TestMysqlUpdate(t *testing.T) {
 	doTestUpdate(NewAssert(t), mg, q)
 }
 
+func TestMysqlUpdateExpr(t *testing.T) {
+	registerMysqlTest()
+	doTestUpdateExpr(NewAssert(t))
+}
+
+func TestMysqlUpdateColumns(t *testing.T) {
+	registerMysqlTest()
+	doTestUpdateColumns(NewAssert(t))
+}
+
+func TestMysqlDirtyTracking(t *testing.T) {
+	registerMysqlTest()
+	doTestDirtyTracking(NewAssert(t))
+}
+
+func TestMysqlOptimisticLocking(t *testing.T) {
+	registerMysqlTest()
+	doTestOptimisticLocking(NewAssert(t))
+}
+
+func TestMysqlSoftDelete(t *testing.T) {
+	registerMysqlTest()
+	doTestSoftDelete(NewAssert(t))
+}
+
+func TestMysqlHooks(t *testing.T) {
+	registerMysqlTest()
+	doTestHooks(NewAssert(t))
+}
+
+func TestMysqlPreload(t *testing.T) {
+	registerMysqlTest()
+	doTestPreload(NewAssert(t))
+}
+
+func TestMysqlManyToMany(t *testing.T) {
+	registerMysqlTest()
+	doTestManyToMany(NewAssert(t))
+}
+
+func TestMysqlNestedJoin(t *testing.T) {
+	registerMysqlTest()
+	doTestNestedJoin(NewAssert(t))
+}
+
+func TestMysqlMultipleRefs(t *testing.T) {
+	registerMysqlTest()
+	doTestMultipleRefs(NewAssert(t))
+}
+
 func TestMysqlValidation(t *testing.T) {
 	mg, q := setupMysqlDb()
 	doTestValidation(NewAssert(t), mg, q)
@@ -131,6 +204,36 @@ func TestMysqlCount(t *testing.T) {
 	doTestCount(NewAssert(t))
 }
 
+func TestMysqlGroupBy(t *testing.T) {
+	registerMysqlTest()
+	doTestGroupBy(NewAssert(t))
+}
+
+func TestMysqlAggregate(t *testing.T) {
+	registerMysqlTest()
+	doTestAggregate(NewAssert(t))
+}
+
+func TestMysqlSelect(t *testing.T) {
+	registerMysqlTest()
+	doTestSelect(NewAssert(t))
+}
+
+func TestMysqlTypedCondition(t *testing.T) {
+	registerMysqlTest()
+	doTestTypedCondition(NewAssert(t))
+}
+
+func TestMysqlWhereIn(t *testing.T) {
+	registerMysqlTest()
+	doTestWhereIn(NewAssert(t))
+}
+
+func TestMysqlSubquery(t *testing.T) {
+	registerMysqlTest()
+	doTestSubquery(NewAssert(t))
+}
+
 func TestMysqlQueryMap(t *testing.T) {
 	mg, q := setupMysqlDb()
 	doTestQueryMap(NewAssert(t), mg, q)
@@ -141,6 +244,21 @@ func TestMysqlBulkInsert(t *testing.T) {
 	doTestBulkInsert(NewAssert(t))
 }
 
+func TestMysqlBulkInsertBatches(t *testing.T) {
+	registerMysqlTest()
+	doTestBulkInsertBatches(NewAssert(t))
+}
+
+func TestMysqlUpsert(t *testing.T) {
+	registerMysqlTest()
+	doTestUpsert(NewAssert(t))
+}
+
+func TestMysqlInsert(t *testing.T) {
+	registerMysqlTest()
+	doTestInsert(NewAssert(t))
+}
+
 func TestMysqlQueryStruct(t *testing.T) {
 	registerMysqlTest()
 	doTestQueryStruct(NewAssert(t))
@@ -169,6 +287,11 @@ func TestMysqlIterate(t *testing.T) {
 	doTestIterate(NewAssert(t))
 }
 
+func TestMysqlContext(t *testing.T) {
+	registerMysqlTest()
+	doTestContext(NewAssert(t))
+}
+
 func TestMysqlAddColumnSQL(t *testing.T) {
 	doTestAddColumSQL(NewAssert(t), mysqlSyntax)
 }
@@ -185,10 +308,22 @@ func TestMysqlInsertSQL(t *testing.T) {
 	doTestInsertSQL(NewAssert(t), mysqlSyntax)
 }
 
+func TestMysqlBulkInsertSQL(t *testing.T) {
+	doTestBulkInsertSQL(NewAssert(t), mysqlSyntax)
+}
+
+func TestMysqlUpsertSQL(t *testing.T) {
+	doTestUpsertSQL(NewAssert(t), mysqlSyntax)
+}
+
 func TestMysqlUpdateSQL(t *testing.T) {
 	doTestUpdateSQL(NewAssert(t), mysqlSyntax)
 }
 
+func TestMysqlUpdateExprSQL(t *testing.T) {
+	doTestUpdateExprSQL(NewAssert(t), mysqlSyntax)
+}
+
 func TestMysqlDeleteSQL(t *testing.T) {
 	doTestDeleteSQL(NewAssert(t), mysqlSyntax)
 }
@@ -200,6 +335,22 @@ func TestMysqlSelectionSQL(t *testing.T) {
 func TestMysqlQuerySQL(t *testing.T) {
 	doTestQuerySQL(NewAssert(t), mysqlSyntax)
 }
+func TestMysqlGroupBySQL(t *testing.T) {
+	doTestGroupBySQL(NewAssert(t), mysqlSyntax)
+}
+
+func TestMysqlSelectDistinctSQL(t *testing.T) {
+	doTestSelectDistinctSQL(NewAssert(t), mysqlSyntax)
+}
+
+func TestMysqlTypedConditionSQL(t *testing.T) {
+	doTestTypedConditionSQL(NewAssert(t), mysqlSyntax)
+}
+
+func TestMysqlSubquerySQL(t *testing.T) {
+	doTestSubquerySQL(NewAssert(t), mysqlSyntax)
+}
+
 func TestMysqlDropTableSQL(t *testing.T) {
 	doTestDropTableSQL(NewAssert(t), mysqlSyntax)
 }
@@ -99,6 +99,54 @@ func (d oracle) insertSql(criteria *criteria) (string, []interface{}) {
 	return sql, values
 }
 
+// Oracle doesn't support multi-row VALUES, the rows are inserted one by one.
+func (d oracle) bulkInsert(q *Qbs, models []*model) ([]int64, error) {
+	ids := make([]int64, len(models))
+	for i, m := range models {
+		q.criteria.model = m
+		id, err := d.insert(q)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// The id of the row is not available, as MERGE doesn't support RETURNING.
+func (d oracle) upsert(q *Qbs, conflictColumns []string) (int64, error) {
+	sql, args := d.dialect.upsertSql(q.criteria, conflictColumns)
+	_, err := q.Exec(sql, args...)
+	return 0, err
+}
+
+func (d oracle) upsertSql(criteria *criteria, conflictColumns []string) (string, []interface{}) {
+	columns, values := criteria.model.columnsAndValues(false)
+	selects := make([]string, 0, len(columns))
+	quotedColumns := make([]string, 0, len(columns))
+	sourceColumns := make([]string, 0, len(columns))
+	for _, c := range columns {
+		selects = append(selects, "? AS "+d.quote(c))
+		quotedColumns = append(quotedColumns, d.quote(c))
+		sourceColumns = append(sourceColumns, "s."+d.quote(c))
+	}
+	ons := make([]string, 0, len(conflictColumns))
+	for _, c := range conflictColumns {
+		ons = append(ons, "t."+d.quote(c)+" = s."+d.quote(c))
+	}
+	sql := "MERGE INTO " + d.quote(criteria.model.table) + " t USING (SELECT " + strings.Join(selects, ", ") +
+		" FROM DUAL) s ON (" + strings.Join(ons, " AND ") + ")"
+	pairs := []string{}
+	for _, c := range upsertUpdateColumns(criteria.model, conflictColumns) {
+		pairs = append(pairs, "t."+d.quote(c)+" = s."+d.quote(c))
+	}
+	if len(pairs) > 0 {
+		sql += " WHEN MATCHED THEN UPDATE SET " + strings.Join(pairs, ", ")
+	}
+	sql += " WHEN NOT MATCHED THEN INSERT (" + strings.Join(quotedColumns, ", ") + ") VALUES (" + strings.Join(sourceColumns, ", ") + ")"
+	return sql, values
+}
+
 func (d oracle) indexExists(mg *Migration, tableName, indexName string) bool {
 	var row *sql.Row
 	var name string
@@ -180,3 +228,34 @@ func (d oracle) dropTableSql(table string) string {
 	a = append(a, d.dialect.quote(table))
 	return strings.Join(a, " ")
 }
+
+// Oracle has no RELEASE SAVEPOINT statement, savepoints are released on commit.
+func (d oracle) releaseSavepointSql(name string) string {
+	return ""
+}
+
+// ORA-00060 is deadlock, ORA-08177 is serialization failure.
+func (d oracle) isRetryableError(err error) bool {
+	errString := err.Error()
+	return strings.Contains(errString, "ORA-00060") || strings.Contains(errString, "ORA-08177")
+}
+
+func (d oracle) isDuplicateKeyError(err error) bool {
+	return strings.Contains(err.Error(), "ORA-00001")
+}
+
+// Oracle only supports read committed and serializable.
+func (d oracle) txOptions(opts sql.TxOptions) sql.TxOptions {
+	switch opts.Isolation {
+	case sql.LevelReadUncommitted:
+		opts.Isolation = sql.LevelReadCommitted
+	case sql.LevelWriteCommitted, sql.LevelRepeatableRead, sql.LevelSnapshot, sql.LevelLinearizable:
+		opts.Isolation = sql.LevelSerializable
+	}
+	return opts
+}
+
+// ORA-01795: maximum number of expressions in a list is 1000.
+func (d oracle) maxInValues() int {
+	return 1000
+}
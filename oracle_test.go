@@ -1,6 +1,7 @@
 package qbs
 
 import (
+	"database/sql"
 	"testing"
 
 //	"time"
@@ -45,3 +46,27 @@ func TestSqlTypeForOrDialect(t *testing.T) {
 		}
 	}
 }
+
+func TestOracleTxOptions(t *testing.T) {
+	assert := NewAssert(t)
+	d := NewOracle()
+	opts := d.txOptions(sql.TxOptions{Isolation: sql.LevelReadUncommitted})
+	assert.Equal(sql.LevelReadCommitted, opts.Isolation)
+	opts = d.txOptions(sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	assert.Equal(sql.LevelSerializable, opts.Isolation)
+	assert.True(opts.ReadOnly)
+}
+
+func TestOracleUpsertSQL(t *testing.T) {
+	assert := NewAssert(t)
+	d := NewOracle()
+	criteria := new(criteria)
+	criteria.model = structPtrToModel(&upsertModel{Code: "a", Amount: 2}, false, nil)
+	sql, args := d.upsertSql(criteria, []string{"code"})
+	sql = d.substituteMarkers(sql)
+	expected := `MERGE INTO "upsert_model" t USING (SELECT $1 AS "code", $2 AS "amount", $3 AS "created" FROM DUAL) s ON (t."code" = s."code") ` +
+		`WHEN MATCHED THEN UPDATE SET t."amount" = s."amount" ` +
+		`WHEN NOT MATCHED THEN INSERT ("code", "amount", "created") VALUES (s."code", s."amount", s."created")`
+	assert.Equal(expected, sql)
+	assert.Equal(3, len(args))
+}
@@ -111,6 +111,10 @@ func (d postgres) sqlType(field modelField) string {
 	panic("invalid sql type for field:" + field.name)
 }
 
+func (d postgres) iLikeSql(column string) string {
+	return column + " ILIKE ?"
+}
+
 func (d postgres) insert(q *Qbs) (int64, error) {
 	sql, args := d.dialect.insertSql(q.criteria)
 	row := q.QueryRow(sql, args...)
@@ -132,6 +136,43 @@ func (d postgres) insertSql(criteria *criteria) (string, []interface{}) {
 	return sql, values
 }
 
+func (d postgres) bulkInsert(q *Qbs, models []*model) ([]int64, error) {
+	sql, args := d.dialect.bulkInsertSql(models)
+	rows, err := q.Query(sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make([]int64, 0, len(models))
+	for rows.Next() {
+		var id interface{}
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		intId, _ := id.(int64)
+		ids = append(ids, intId)
+	}
+	return ids, rows.Err()
+}
+
+func (d postgres) bulkInsertSql(models []*model) (string, []interface{}) {
+	sql, values := d.base.bulkInsertSql(models)
+	sql += " RETURNING " + d.dialect.quote(models[0].pk.name)
+	return sql, values
+}
+
+func (d postgres) upsert(q *Qbs, conflictColumns []string) (int64, error) {
+	sql, args := d.dialect.upsertSql(q.criteria, conflictColumns)
+	return returningId(q, sql, args)
+}
+
+func (d postgres) upsertSql(criteria *criteria, conflictColumns []string) (string, []interface{}) {
+	sql, values := d.base.upsertSql(criteria, conflictColumns)
+	sql += " RETURNING " + d.dialect.quote(criteria.model.pk.name)
+	return sql, values
+}
+
 func (d postgres) indexExists(mg *Migration, tableName, indexName string) bool {
 	var row *sql.Row
 	var name string
@@ -185,3 +226,35 @@ func (d postgres) primaryKeySql(isString bool, size int) string {
 	}
 	return "bigserial PRIMARY KEY"
 }
+
+// SQLSTATE 40001 is serialization failure, 40P01 is deadlock detected.
+func (d postgres) isRetryableError(err error) bool {
+	if e, ok := err.(interface {
+		SQLState() string
+	}); ok {
+		state := e.SQLState()
+		return state == "40001" || state == "40P01"
+	}
+	errString := err.Error()
+	return strings.Contains(errString, "could not serialize access") || strings.Contains(errString, "deadlock detected")
+}
+
+func (d postgres) isDuplicateKeyError(err error) bool {
+	if e, ok := err.(interface {
+		SQLState() string
+	}); ok {
+		return e.SQLState() == "23505"
+	}
+	return strings.Contains(err.Error(), "duplicate key value violates unique constraint")
+}
+
+// Snapshot isolation is called repeatable read in postgres.
+func (d postgres) txOptions(opts sql.TxOptions) sql.TxOptions {
+	switch opts.Isolation {
+	case sql.LevelWriteCommitted, sql.LevelSnapshot:
+		opts.Isolation = sql.LevelRepeatableRead
+	case sql.LevelLinearizable:
+		opts.Isolation = sql.LevelSerializable
+	}
+	return opts
+}
@@ -1,6 +1,7 @@
 package qbs
 
 import (
+	"errors"
 	_ "github.com/lib/pq"
 	"testing"
 	//"time"
@@ -19,6 +20,13 @@ var pgSyntax = dialectSyntax{
 	`ALTER TABLE "a" ADD COLUMN "newc" varchar(100)`,
 	`CREATE UNIQUE INDEX "iname" ON "itable" ("a", "b", "c")`,
 	`CREATE INDEX "iname2" ON "itable2" ("d", "e")`,
+	`SELECT "grade", COUNT(*) AS "total" FROM "student" WHERE score >= $1 GROUP BY "grade" HAVING COUNT(*) > $2 ORDER BY "grade"`,
+	`SELECT DISTINCT "post"."author_id", "author"."name" AS author___name FROM "post" LEFT JOIN "user" AS "author" ON "post"."author_id" = "author"."id"`,
+	`SELECT "name", "grade", "score" FROM "student" WHERE (("grade" BETWEEN $1 AND $2) AND (NOT ("score" NOT IN ($3, $4)))) OR ("name" ILIKE $5)`,
+	`SELECT "name", "grade", "score" FROM "student" WHERE (("grade" = $1) AND ("name" IN (SELECT "name" FROM "student" WHERE "score" > $2 LIMIT $3))) OR ("score" > (SELECT "name" FROM "student" WHERE "score" > $4 LIMIT $5)) LIMIT $6`,
+	`INSERT INTO "bulk_model" ("name") VALUES ($1), ($2) RETURNING "id"`,
+	`INSERT INTO "upsert_model" ("code", "amount", "created") VALUES ($1, $2, $3) ON CONFLICT ("code") DO UPDATE SET "amount" = EXCLUDED."amount" RETURNING "id"`,
+	`UPDATE "counter" SET "clicks" = "clicks" - $1, "updated" = CURRENT_TIMESTAMP, "views" = "views" + $2 WHERE "id" = $3`,
 }
 
 func registerPgTest() {
@@ -76,6 +84,21 @@ func TestPgTransaction(t *testing.T) {
 	doTestTransaction(NewAssert(t))
 }
 
+func TestPgNestedTransaction(t *testing.T) {
+	registerPgTest()
+	doTestNestedTransaction(NewAssert(t))
+}
+
+func TestPgWithTransaction(t *testing.T) {
+	registerPgTest()
+	doTestWithTransaction(NewAssert(t), errors.New("pq: could not serialize access due to concurrent update"))
+}
+
+func TestPgBeginWith(t *testing.T) {
+	registerPgTest()
+	doTestBeginWith(NewAssert(t))
+}
+
 func TestPgSaveAndDelete(t *testing.T) {
 	mg, q := setupPgDb()
 	doTestSaveAndDelete(NewAssert(t), mg, q)
@@ -106,6 +129,56 @@ func TestPgUpdate(t *testing.T) {
 	doTestUpdate(NewAssert(t), mg, q)
 }
 
+func TestPgUpdateExpr(t *testing.T) {
+	registerPgTest()
+	doTestUpdateExpr(NewAssert(t))
+}
+
+func TestPgUpdateColumns(t *testing.T) {
+	registerPgTest()
+	doTestUpdateColumns(NewAssert(t))
+}
+
+func TestPgDirtyTracking(t *testing.T) {
+	registerPgTest()
+	doTestDirtyTracking(NewAssert(t))
+}
+
+func TestPgOptimisticLocking(t *testing.T) {
+	registerPgTest()
+	doTestOptimisticLocking(NewAssert(t))
+}
+
+func TestPgSoftDelete(t *testing.T) {
+	registerPgTest()
+	doTestSoftDelete(NewAssert(t))
+}
+
+func TestPgHooks(t *testing.T) {
+	registerPgTest()
+	doTestHooks(NewAssert(t))
+}
+
+func TestPgPreload(t *testing.T) {
+	registerPgTest()
+	doTestPreload(NewAssert(t))
+}
+
+func TestPgManyToMany(t *testing.T) {
+	registerPgTest()
+	doTestManyToMany(NewAssert(t))
+}
+
+func TestPgNestedJoin(t *testing.T) {
+	registerPgTest()
+	doTestNestedJoin(NewAssert(t))
+}
+
+func TestPgMultipleRefs(t *testing.T) {
+	registerPgTest()
+	doTestMultipleRefs(NewAssert(t))
+}
+
 func TestPgValidation(t *testing.T) {
 	mg, q := setupPgDb()
 	doTestValidation(NewAssert(t), mg, q)
@@ -126,6 +199,36 @@ func TestPgCount(t *testing.T) {
 	doTestCount(NewAssert(t))
 }
 
+func TestPgGroupBy(t *testing.T) {
+	registerPgTest()
+	doTestGroupBy(NewAssert(t))
+}
+
+func TestPgAggregate(t *testing.T) {
+	registerPgTest()
+	doTestAggregate(NewAssert(t))
+}
+
+func TestPgSelect(t *testing.T) {
+	registerPgTest()
+	doTestSelect(NewAssert(t))
+}
+
+func TestPgTypedCondition(t *testing.T) {
+	registerPgTest()
+	doTestTypedCondition(NewAssert(t))
+}
+
+func TestPgWhereIn(t *testing.T) {
+	registerPgTest()
+	doTestWhereIn(NewAssert(t))
+}
+
+func TestPgSubquery(t *testing.T) {
+	registerPgTest()
+	doTestSubquery(NewAssert(t))
+}
+
 func TestPgQueryMap(t *testing.T) {
 	mg, q := setupPgDb()
 	doTestQueryMap(NewAssert(t), mg, q)
@@ -136,6 +239,21 @@ func TestPgBulkInsert(t *testing.T) {
 	doTestBulkInsert(NewAssert(t))
 }
 
+func TestPgBulkInsertBatches(t *testing.T) {
+	registerPgTest()
+	doTestBulkInsertBatches(NewAssert(t))
+}
+
+func TestPgUpsert(t *testing.T) {
+	registerPgTest()
+	doTestUpsert(NewAssert(t))
+}
+
+func TestPgInsert(t *testing.T) {
+	registerPgTest()
+	doTestInsert(NewAssert(t))
+}
+
 func TestPgQueryStruct(t *testing.T) {
 	registerPgTest()
 	doTestQueryStruct(NewAssert(t))
@@ -151,6 +269,11 @@ func TestPgIterate(t *testing.T) {
 	doTestIterate(NewAssert(t))
 }
 
+func TestPgContext(t *testing.T) {
+	registerPgTest()
+	doTestContext(NewAssert(t))
+}
+
 func TestPgAddColumnSQL(t *testing.T) {
 	doTestAddColumSQL(NewAssert(t), pgSyntax)
 }
@@ -167,10 +290,22 @@ func TestPgInsertSQL(t *testing.T) {
 	doTestInsertSQL(NewAssert(t), pgSyntax)
 }
 
+func TestPgBulkInsertSQL(t *testing.T) {
+	doTestBulkInsertSQL(NewAssert(t), pgSyntax)
+}
+
+func TestPgUpsertSQL(t *testing.T) {
+	doTestUpsertSQL(NewAssert(t), pgSyntax)
+}
+
 func TestPgUpdateSQL(t *testing.T) {
 	doTestUpdateSQL(NewAssert(t), pgSyntax)
 }
 
+func TestPgUpdateExprSQL(t *testing.T) {
+	doTestUpdateExprSQL(NewAssert(t), pgSyntax)
+}
+
 func TestPgDeleteSQL(t *testing.T) {
 	doTestDeleteSQL(NewAssert(t), pgSyntax)
 }
@@ -183,6 +318,22 @@ func TestPgQuerySQL(t *testing.T) {
 	doTestQuerySQL(NewAssert(t), pgSyntax)
 }
 
+func TestPgGroupBySQL(t *testing.T) {
+	doTestGroupBySQL(NewAssert(t), pgSyntax)
+}
+
+func TestPgSelectDistinctSQL(t *testing.T) {
+	doTestSelectDistinctSQL(NewAssert(t), pgSyntax)
+}
+
+func TestPgTypedConditionSQL(t *testing.T) {
+	doTestTypedConditionSQL(NewAssert(t), pgSyntax)
+}
+
+func TestPgSubquerySQL(t *testing.T) {
+	doTestSubquerySQL(NewAssert(t), pgSyntax)
+}
+
 func TestPgDropTableSQL(t *testing.T) {
 	doTestDropTableSQL(NewAssert(t), pgSyntax)
 }
@@ -0,0 +1,130 @@
+package qbs
+
+import (
+	"database/sql"
+	"reflect"
+)
+
+// Preload loads the has-many associations of the structs found by the following Find or FindAll,
+// the field should be a slice of struct pointer like `Comments []*Comment` of Post, its structs are
+// loaded by one query with an IN condition per field, which avoids querying the children of each struct.
+// The children are matched by the field of the child struct which is named after the parent struct,
+// like PostId of Comment, it can be specified by the fk tag like `Posts []*Post qbs:"fk:AuthorId"`.
+// A field with the m2m tag like `Tags []*Tag qbs:"m2m:post_tag"` is loaded through the join table, see Associate.
+// The slice fields are replaced, so a struct without children gets an empty slice.
+func (q *Qbs) Preload(fieldNames ...string) *Qbs {
+	q.criteria.preloads = append(q.criteria.preloads, fieldNames...)
+	return q
+}
+
+// preload loads the associations of the fields for the structs in the slice from index start.
+func (q *Qbs) preload(sliceValue reflect.Value, start int, fieldNames []string) error {
+	if sliceValue.Len() <= start {
+		return nil
+	}
+	parents := sliceValue.Slice(start, sliceValue.Len())
+	parentType := parents.Type().Elem().Elem()
+	for _, name := range fieldNames {
+		field, ok := parentType.FieldByName(name)
+		if !ok {
+			panic("Can not find preload field " + name)
+		}
+		if !isAssociationField(field) {
+			panic("Preload field " + name + " is not a slice of struct pointer")
+		}
+		fd := new(modelField)
+		parseTags(fd, field.Tag.Get("qbs"))
+		byKey, keys := groupParents(parents, field)
+		var err error
+		if fd.m2m != "" {
+			err = q.preloadManyToMany(byKey, keys, field, newManyToMany(parentType, field, fd.m2m))
+		} else {
+			err = q.preloadHasMany(byKey, keys, parentType, field, fd.fk)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isAssociationField reports whether the field is a slice of struct pointer.
+func isAssociationField(field reflect.StructField) bool {
+	return field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Ptr &&
+		field.Type.Elem().Elem().Kind() == reflect.Struct
+}
+
+// groupParents resets the field of the parents to an empty slice, and groups the parents by primary key,
+// the keys are returned in order.
+func groupParents(parents reflect.Value, field reflect.StructField) (map[interface{}][]reflect.Value, []interface{}) {
+	pk := structPtrToModel(parents.Index(0).Interface(), false, nil).pk
+	if pk == nil {
+		panic("no primary key field")
+	}
+	byKey := make(map[interface{}][]reflect.Value, parents.Len())
+	keys := make([]interface{}, 0, parents.Len())
+	for i := 0; i < parents.Len(); i++ {
+		parent := parents.Index(i).Elem()
+		parent.FieldByIndex(field.Index).Set(reflect.MakeSlice(field.Type, 0, 0))
+		key := preloadKey(parent.FieldByName(pk.camelName))
+		if _, ok := byKey[key]; !ok {
+			keys = append(keys, key)
+		}
+		byKey[key] = append(byKey[key], parent)
+	}
+	return byKey, keys
+}
+
+func appendChild(parents []reflect.Value, field reflect.StructField, child reflect.Value) {
+	for _, parent := range parents {
+		slice := parent.FieldByIndex(field.Index)
+		slice.Set(reflect.Append(slice, child))
+	}
+}
+
+func (q *Qbs) preloadHasMany(byKey map[interface{}][]reflect.Value, keys []interface{},
+	parentType reflect.Type, field reflect.StructField, fkName string) error {
+	childType := field.Type.Elem().Elem()
+	if fkName == "" {
+		fkName = parentType.Name() + "Id"
+	}
+	if _, ok := childType.FieldByName(fkName); !ok {
+		panic("Can not find foreign key field " + fkName + " in " + childType.Name())
+	}
+	children := reflect.New(field.Type)
+	childTable := tableName(children.Interface())
+	column := q.Dialect.quote(childTable) + "." + q.Dialect.quote(FieldNameToColumnName(fkName))
+	if err := q.Condition(NewInCondition(column, keys)).FindAll(children.Interface()); err != nil {
+		return err
+	}
+	children = children.Elem()
+	for i := 0; i < children.Len(); i++ {
+		child := children.Index(i)
+		appendChild(byKey[preloadKey(child.Elem().FieldByName(fkName))], field, child)
+	}
+	return nil
+}
+
+// preloadKey converts the primary key or foreign key value to a comparable map key,
+// so an int64 primary key matches a sql.NullInt64 or *int64 foreign key.
+func preloadKey(v reflect.Value) interface{} {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if n, ok := v.Interface().(sql.NullInt64); ok {
+		if !n.Valid {
+			return nil
+		}
+		return n.Int64
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint())
+	}
+	return v.Interface()
+}
@@ -1,35 +1,61 @@
 package qbs
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"log"
-	"os"
 	"reflect"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 )
 
-var driver, driverSource, dbName string
-var dial Dialect
-var connectionLimit chan struct{}
-var blockingOnLimit bool
 var ConnectionLimitError = errors.New("Connection limit reached")
-var db *sql.DB
-var stmtMap map[string]*sql.Stmt
-var mu *sync.RWMutex
-var queryLogger *log.Logger = log.New(os.Stdout, "qbs:", log.LstdFlags)
-var errorLogger *log.Logger = log.New(os.Stderr, "qbs:", log.LstdFlags)
+
+// ErrDBClosed is returned by GetQbs and GetMigration of a DB which has been closed.
+var ErrDBClosed = errors.New("qbs: database is closed")
+
+// ErrStaleObject is returned by Update and Save if the struct has a version field,
+// and the row has been updated or deleted since the struct was loaded.
+var ErrStaleObject = errors.New("qbs: stale object, the row has been updated or deleted")
+
+// DuplicateKeyError is returned by Insert if the row violates the primary key or an unique index.
+type DuplicateKeyError struct {
+	Err error
+}
+
+func (e *DuplicateKeyError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DuplicateKeyError) Unwrap() error {
+	return e.Err
+}
 
 type Qbs struct {
 	Dialect      Dialect
 	Log          bool //Set to true to print out sql statement.
+	db           *DB
+	ctx          context.Context
 	tx           *sql.Tx
 	txStmtMap    map[string]*sql.Stmt
+	savepoints   []savepoint
 	criteria     *criteria
 	firstTxError error
+	dryRun       bool
+	statements   []Statement
+	snapshots    map[interface{}]map[string]interface{}
+	snapshotUndo []map[interface{}]map[string]interface{} // previous snapshots per transaction and savepoint
+	noTracking   bool
+}
+
+// savepoint represents a nested transaction, it keeps the first error of the enclosing transaction,
+// so errors inside a rolled back savepoint will not be reported on outer Commit.
+type savepoint struct {
+	name         string
+	firstTxError error
 }
 
 type Validator interface {
@@ -38,12 +64,12 @@ type Validator interface {
 
 //Register a database, should be call at the beginning of the application.
 func Register(driverName, driverSourceName, databaseName string, dialect Dialect) {
-	driverSource = driverSourceName
-	dbName = databaseName
-	if db == nil {
+	defaultDB.driverSource = driverSourceName
+	defaultDB.dbName = databaseName
+	if defaultDB.sqlDb == nil || defaultDB.isClosed() {
 		var err error
 		var database *sql.DB
-		database, err = sql.Open(driverName, driverSource)
+		database, err = sql.Open(driverName, driverSourceName)
 		if err != nil {
 			panic(err)
 		}
@@ -52,22 +78,22 @@ func Register(driverName, driverSourceName, databaseName string, dialect Dialect
 }
 
 func RegisterWithDb(driverName string, database *sql.DB, dialect Dialect) {
-	driver = driverName
-	dial = dialect
-	db = database
-	db.SetMaxIdleConns(100)
-	stmtMap = make(map[string]*sql.Stmt)
-	mu = new(sync.RWMutex)
+	defaultDB.setSqlDb(driverName, database, dialect)
 }
 
 //A safe and easy way to work with *Qbs instance without the need to open and close it.
 func WithQbs(task func(*Qbs) error) error {
-	q, err := GetQbs()
-	if err != nil {
-		return err
-	}
-	defer q.Close()
-	return task(q)
+	return defaultDB.WithQbs(task)
+}
+
+// A safe and easy way to run task in a transaction, see Qbs.Transaction.
+func WithTransaction(task func(*Qbs) error) error {
+	return defaultDB.WithTransaction(task)
+}
+
+// Like WithTransaction, but retries task on deadlock or serialization failure, see Qbs.TransactionWithRetry.
+func WithTransactionRetry(maxRetries int, task func(*Qbs) error) error {
+	return defaultDB.WithTransactionRetry(maxRetries, task)
 }
 
 //Get an Qbs instance, should call `defer q.Close()` next, like:
@@ -81,45 +107,33 @@ func WithQbs(task func(*Qbs) error) error {
 //		...
 //
 func GetQbs() (q *Qbs, err error) {
-	if driver == "" || dial == nil {
-		panic("database driver has not been registered, should call Register first.")
-	}
-	if connectionLimit != nil {
-		if blockingOnLimit {
-			connectionLimit <- struct{}{}
-		} else {
-			select {
-			case connectionLimit <- struct{}{}:
-			default:
-				return nil, ConnectionLimitError
-			}
-		}
-	}
-	q = new(Qbs)
-	q.Dialect = dial
-	q.criteria = new(criteria)
-	return q, nil
+	return defaultDB.GetQbs()
+}
+
+// GetQbsContext is like GetQbs, but it stops waiting for the connection limit when ctx is done,
+// and the returned Qbs instance performs every statement with ctx.
+func GetQbsContext(ctx context.Context) (q *Qbs, err error) {
+	return defaultDB.GetQbsContext(ctx)
 }
 
 //The default connection pool size is 100.
 func ChangePoolSize(size int) {
-	db.SetMaxIdleConns(size)
+	defaultDB.ChangePoolSize(size)
 }
 
 func SetLogger(query *log.Logger, err *log.Logger) {
-	queryLogger = query
-	errorLogger = err
+	defaultDB.SetLogger(query, err)
 }
 
 //Set the connection limit, there is no limit by default.
 //If blocking is true, GetQbs method will be blocked, otherwise returns ConnectionLimitError.
 func SetConnectionLimit(maxCon int, blocking bool) {
-	if maxCon > 0 {
-		connectionLimit = make(chan struct{}, maxCon)
-	} else if maxCon < 0 {
-		connectionLimit = nil
-	}
-	blockingOnLimit = blocking
+	defaultDB.SetConnectionLimit(maxCon, blocking)
+}
+
+// SetRetryBackoff sets the backoff of TransactionWithRetry for the default database, see DB.SetRetryBackoff.
+func SetRetryBackoff(backoff func(retry int) time.Duration) {
+	defaultDB.SetRetryBackoff(backoff)
 }
 
 // Create a new criteria for subsequent query
@@ -127,17 +141,139 @@ func (q *Qbs) Reset() {
 	q.criteria = new(criteria)
 }
 
+// WithContext sets the context used by all subsequent statements and transactions,
+// so a cancelled or expired context aborts the in-flight SQL.
+func (q *Qbs) WithContext(ctx context.Context) *Qbs {
+	q.ctx = ctx
+	return q
+}
+
+// Context returns the context set by WithContext, or context.Background if none was set.
+func (q *Qbs) Context() context.Context {
+	if q.ctx == nil {
+		return context.Background()
+	}
+	return q.ctx
+}
+
 // Begin create a transaction object internally
 // You can perform queries with the same Qbs object
 // no matter it is in transaction or not.
-// It panics if it's already in a transaction.
+// If it's already in a transaction, a savepoint will be created as a nested transaction,
+// the following Commit or Rollback will release or roll back to the savepoint.
 func (q *Qbs) Begin() error {
+	return q.BeginWith(nil)
+}
+
+// BeginWith is like Begin, but starts the transaction with the isolation level and read-only mode in opts.
+// If the database doesn't support the isolation level, the closest stronger level will be used.
+// The options are ignored in a nested transaction, as the savepoint shares the outer transaction.
+func (q *Qbs) BeginWith(opts *sql.TxOptions) error {
 	if q.tx != nil {
-		panic("cannot start nested transaction")
+		name := "qbs_sp_" + strconv.Itoa(len(q.savepoints)+1)
+		err := q.execSavepointSql(q.Dialect.savepointSql(name))
+		if err != nil {
+			return q.updateTxError(err)
+		}
+		q.savepoints = append(q.savepoints, savepoint{name, q.firstTxError})
+		q.snapshotUndo = append(q.snapshotUndo, nil)
+		q.firstTxError = nil
+		return nil
 	}
-	tx, err := db.Begin()
+	if opts != nil {
+		o := q.Dialect.txOptions(*opts)
+		opts = &o
+	}
+	tx, err := q.database().sqlDb.BeginTx(q.Context(), opts)
 	q.tx = tx
+	q.firstTxError = nil
 	q.txStmtMap = make(map[string]*sql.Stmt)
+	if err == nil {
+		q.snapshotUndo = []map[interface{}]map[string]interface{}{nil}
+	}
+	return err
+}
+
+// Transaction runs task in a transaction, it commits if task returns nil and no error occurred inside the transaction,
+// otherwise it rolls back and returns the error of task, or the first error occurred.
+// The transaction is also rolled back if task panics, and the panic is propagated.
+// If q is already in a transaction, task runs in a nested transaction.
+func (q *Qbs) Transaction(task func(*Qbs) error) (err error) {
+	if err = q.Begin(); err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			q.Rollback()
+			panic(r)
+		}
+	}()
+	if err = task(q); err != nil {
+		q.Rollback()
+		return err
+	}
+	// an error swallowed by task, like the one of Count, aborts the transaction as well.
+	if err = q.firstTxError; err != nil {
+		q.Rollback()
+		return err
+	}
+	return q.Commit()
+}
+
+// TransactionWithRetry is like Transaction, but the whole task will be run again, up to maxRetries times,
+// if the dialect classifies the error as a deadlock or serialization failure.
+// It waits before each retry as the backoff set by SetRetryBackoff, the waiting stops when the context is done.
+// Task will not be retried if q is already in a transaction, as the outer transaction has been aborted.
+func (q *Qbs) TransactionWithRetry(maxRetries int, task func(*Qbs) error) (err error) {
+	nested := q.InTransaction()
+	for i := 0; ; i++ {
+		err = q.Transaction(task)
+		if err == nil || nested || i >= maxRetries || !q.Dialect.isRetryableError(err) {
+			return err
+		}
+		q.Reset()
+		if err = q.waitRetry(i + 1); err != nil {
+			return err
+		}
+	}
+}
+
+func (q *Qbs) waitRetry(retry int) error {
+	backoff := defaultRetryBackoff
+	if q.db != nil && q.db.retryBackoff != nil {
+		backoff = q.db.retryBackoff
+	}
+	d := backoff(retry)
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-q.Context().Done():
+		return q.Context().Err()
+	}
+}
+
+func defaultRetryBackoff(retry int) time.Duration {
+	d := 10 * time.Millisecond
+	for i := 1; i < retry && d < time.Second; i++ {
+		d *= 2
+	}
+	if d > time.Second {
+		d = time.Second
+	}
+	return d
+}
+
+func (q *Qbs) execSavepointSql(query string) error {
+	if query == "" {
+		return nil
+	}
+	q.log(query)
+	_, err := q.tx.ExecContext(q.Context(), query)
 	return err
 }
 
@@ -147,8 +283,8 @@ func (q *Qbs) InTransaction() bool {
 
 func (q *Qbs) updateTxError(e error) error {
 	if e != nil {
-		if errorLogger != nil {
-			errorLogger.Println(e)
+		if q.db.errorLogger != nil {
+			q.db.errorLogger.Println(e)
 		}
 		// don't shadow the first error
 		if q.firstTxError == nil {
@@ -160,10 +296,24 @@ func (q *Qbs) updateTxError(e error) error {
 
 // Commit commits a started transaction and will report the first error that
 // occurred inside the transaction.
+// In a nested transaction, it releases the savepoint, the first error will also
+// be reported on the outer Commit.
 func (q *Qbs) Commit() error {
+	if n := len(q.savepoints); n > 0 {
+		sp := q.savepoints[n-1]
+		q.savepoints = q.savepoints[:n-1]
+		q.updateTxError(q.execSavepointSql(q.Dialect.releaseSavepointSql(sp.name)))
+		q.releaseSnapshots()
+		err := q.firstTxError
+		if sp.firstTxError != nil {
+			q.firstTxError = sp.firstTxError
+		}
+		return err
+	}
 	err := q.tx.Commit()
 	q.updateTxError(err)
 	q.tx = nil
+	q.snapshotUndo = nil
 	for _, v := range q.txStmtMap {
 		v.Close()
 	}
@@ -172,8 +322,19 @@ func (q *Qbs) Commit() error {
 }
 
 // Rollback rolls back a started transaction.
+// In a nested transaction, it only rolls back to the savepoint, and the errors
+// occurred after the savepoint will not be reported on the outer Commit.
 func (q *Qbs) Rollback() error {
+	if n := len(q.savepoints); n > 0 {
+		sp := q.savepoints[n-1]
+		q.savepoints = q.savepoints[:n-1]
+		err := q.execSavepointSql(q.Dialect.rollbackToSavepointSql(sp.name))
+		q.restoreSnapshots()
+		q.firstTxError = sp.firstTxError
+		return q.updateTxError(err)
+	}
 	err := q.tx.Rollback()
+	q.restoreSnapshots()
 	q.tx = nil
 	for _, v := range q.txStmtMap {
 		v.Close()
@@ -194,11 +355,21 @@ func (q *Qbs) WhereEqual(column string, value interface{}) *Qbs {
 	return q
 }
 
-func (q *Qbs) WhereIn(column string, values []interface{}) *Qbs {
+// Snakecase column name, the values can be a slice of any type.
+// An empty slice makes an always false condition.
+// If there are more values than the database's placeholder limit, FindAll will query by chunks,
+// it only happens when there is no limit, offset or order by.
+func (q *Qbs) WhereIn(column string, values interface{}) *Qbs {
 	q.criteria.condition = NewInCondition(column, values)
 	return q
 }
 
+// WhereInSubquery is a shortcut method to call Condition(InSubquery(column, sub)).
+func (q *Qbs) WhereInSubquery(column string, sub *Subquery) *Qbs {
+	q.criteria.condition = InSubquery(column, sub)
+	return q
+}
+
 //Condition defines the SQL "WHERE" clause
 //If other condition can be inferred by the struct argument in
 //Find method, it will be merged with AND
@@ -227,6 +398,40 @@ func (q *Qbs) OrderByDesc(path string) *Qbs {
 	return q
 }
 
+// Snakecase column names, the struct fields with `expr` tag can be used to select aggregate expressions, like:
+//
+//		type GradeCount struct {
+//			Grade int
+//			Count int64 `qbs:"expr:COUNT(*)"`
+//		}
+//
+func (q *Qbs) GroupBy(columns ...string) *Qbs {
+	for _, v := range columns {
+		q.criteria.groupBys = append(q.criteria.groupBys, q.Dialect.quote(v))
+	}
+	return q
+}
+
+// Having defines the SQL "HAVING" clause, should be used along with GroupBy.
+func (q *Qbs) Having(expr string, args ...interface{}) *Qbs {
+	q.criteria.having = NewCondition(expr, args...)
+	return q
+}
+
+// Camel case field names, only the selected fields will be queried by Find, FindAll and Iterate.
+// Fields of the joined struct can be selected like "Author.Name", or "Author" for all of its fields,
+// the join is omitted if none of its fields is selected.
+func (q *Qbs) Select(fieldName ...string) *Qbs {
+	q.criteria.selectFields = fieldName
+	return q
+}
+
+// Distinct removes duplicated rows in the result, should be used along with Select.
+func (q *Qbs) Distinct() *Qbs {
+	q.criteria.distinct = true
+	return q
+}
+
 // Camel case field names
 func (q *Qbs) OmitFields(fieldName ...string) *Qbs {
 	q.criteria.omitFields = fieldName
@@ -238,15 +443,69 @@ func (q *Qbs) OmitJoin() *Qbs {
 	return q
 }
 
+// JoinDepth sets how many levels of referenced structs are joined in the query, the default is 1.
+// For example, with depth 2, a Comment with PostId and Post fields is joined with the post, and the post's
+// author if Post has AuthorId and Author fields, the columns of the author are aliased like post___author___name.
+func (q *Qbs) JoinDepth(depth int) *Qbs {
+	q.criteria.joinDepth = depth
+	return q
+}
+
+// queryModel parses the struct to query, the referenced structs are joined as OmitJoin and JoinDepth set.
+func (q *Qbs) queryModel(structPtr interface{}) *model {
+	depth := q.criteria.joinDepth
+	if depth == 0 {
+		depth = 1
+	}
+	if q.criteria.omitJoin {
+		depth = 0
+	}
+	return structPtrToModelDepth(structPtr, depth > 0, depth, q.criteria.omitFields)
+}
+
+// WithDeleted includes the soft deleted rows in Find, FindAll, Count and Iterate,
+// which are excluded by default if the struct has a field tagged with `qbs:"deleted"`.
+func (q *Qbs) WithDeleted() *Qbs {
+	q.criteria.deletedScope = withDeleted
+	return q
+}
+
+// OnlyDeleted makes Find, FindAll, Count and Iterate return only the soft deleted rows.
+func (q *Qbs) OnlyDeleted() *Qbs {
+	q.criteria.deletedScope = onlyDeleted
+	return q
+}
+
 // Perform select query by parsing the struct's type and then fill the values into the struct
 // All fields of supported types in the struct will be added in select clause.
 // If Id value is provided, it will be added into the where clause
 // If a foreign key field with its referenced struct pointer field are provided,
 // It will perform a join query, the referenced struct pointer field will be filled in
 // the values obtained by the query.
+// Each referenced field is joined with the alias of its name, so a struct can reference the same struct more than once
+// like `CreatorId int64 qbs:"fk:Creator"` and `AssigneeId int64 qbs:"fk:Assignee"`, or reference itself like ParentId,
+// the referenced field specified by fk or join tag doesn't need to match the id field's name.
 // If not found, "sql.ErrNoRows" will be returned.
+// The associations set by Preload are loaded, then AfterFind is called if the struct implements AfterFinder.
 func (q *Qbs) Find(structPtr interface{}) error {
-	q.criteria.model = structPtrToModel(structPtr, !q.criteria.omitJoin, q.criteria.omitFields)
+	preloads := q.criteria.preloads
+	q.findCriteria(structPtr)
+	query, args := q.Dialect.querySql(q.criteria)
+	if err := q.doQueryRow(structPtr, query, args...); err != nil {
+		return err
+	}
+	if len(preloads) > 0 {
+		parents := reflect.New(reflect.SliceOf(reflect.TypeOf(structPtr))).Elem()
+		parents = reflect.Append(parents, reflect.ValueOf(structPtr))
+		if err := q.preload(parents, 0, preloads); err != nil {
+			return err
+		}
+	}
+	return q.afterFind(structPtr)
+}
+
+func (q *Qbs) findCriteria(structPtr interface{}) {
+	q.criteria.model = q.queryModel(structPtr)
 	q.criteria.limit = 1
 	if !q.criteria.model.pkZero() {
 		idPath := q.Dialect.quote(q.criteria.model.table) + "." + q.Dialect.quote(q.criteria.model.pk.name)
@@ -257,20 +516,138 @@ func (q *Qbs) Find(structPtr interface{}) error {
 			q.criteria.condition = idCondition.AndCondition(q.criteria.condition)
 		}
 	}
-	query, args := q.Dialect.querySql(q.criteria)
-	return q.doQueryRow(structPtr, query, args...)
+}
+
+// Subquery builds a select statement of the struct type with the criteria set so far instead of performing it,
+// the criteria is reset so the outer query can be built next, like:
+//
+//		sub := q.Select("AuthorId").Where("title LIKE ?", "qbs%").Subquery(new(Post))
+//		err := q.WhereInSubquery("id", sub).FindAll(&users)
+//
+func (q *Qbs) Subquery(structPtr interface{}) *Subquery {
+	defer q.Reset()
+	q.criteria.model = q.queryModel(structPtr)
+	query, args := q.Dialect.selectSql(q.criteria)
+	return &Subquery{query, args}
 }
 
 // Similar to Find, except that FindAll accept pointer of slice of struct pointer,
-// rows will be appended to the slice.
+// rows will be appended to the slice, AfterFind is called on each appended struct after all rows and
+// the associations set by Preload are loaded.
 func (q *Qbs) FindAll(ptrOfSliceOfStructPtr interface{}) error {
-	strucType := reflect.TypeOf(ptrOfSliceOfStructPtr).Elem().Elem().Elem()
-	strucPtr := reflect.New(strucType).Interface()
-	q.criteria.model = structPtrToModel(strucPtr, !q.criteria.omitJoin, q.criteria.omitFields)
+	sliceValue := reflect.Indirect(reflect.ValueOf(ptrOfSliceOfStructPtr))
+	start := sliceValue.Len()
+	preloads := q.criteria.preloads
+	if err := q.findAll(ptrOfSliceOfStructPtr); err != nil {
+		return err
+	}
+	if err := q.preload(sliceValue, start, preloads); err != nil {
+		return err
+	}
+	return q.afterFindAll(sliceValue, start)
+}
+
+func (q *Qbs) findAll(ptrOfSliceOfStructPtr interface{}) error {
+	defer q.Reset()
+	q.findAllCriteria(ptrOfSliceOfStructPtr)
+	if chunks := q.criteria.inChunks(q.Dialect); chunks != nil {
+		con := q.criteria.condition
+		values := con.args
+		defer func() {
+			con.args = values
+		}()
+		for _, chunk := range chunks {
+			con.args = chunk
+			query, args := q.Dialect.querySql(q.criteria)
+			err := q.doQueryRows(ptrOfSliceOfStructPtr, query, args...)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 	query, args := q.Dialect.querySql(q.criteria)
 	return q.doQueryRows(ptrOfSliceOfStructPtr, query, args...)
 }
 
+func (q *Qbs) findAllCriteria(ptrOfSliceOfStructPtr interface{}) {
+	strucType := reflect.TypeOf(ptrOfSliceOfStructPtr).Elem().Elem().Elem()
+	strucPtr := reflect.New(strucType).Interface()
+	q.criteria.model = q.queryModel(strucPtr)
+}
+
+// Op is the operation rendered by ToSQL.
+type Op int
+
+const (
+	OpFind Op = iota
+	OpFindAll
+	OpSave
+	OpUpdate
+	OpDelete
+)
+
+// ToSQL renders the final SQL and arguments of the operation with the criteria set so far instead of performing it,
+// the criteria is reset afterwards. The structPtr argument is the same as the operation's,
+// for OpFindAll, it's pointer of slice of struct pointer.
+// OpDelete renders the update of the deleted field if the struct has one.
+// As the existence of the row is not queried, OpSave renders an insert if the primary key is zero,
+// otherwise an update by the primary key, the created and updated time fields are set to now like Save.
+// The updates of OpSave and OpUpdate are rendered like Update, with the version condition and only the changed columns,
+// the query is empty if no column is changed.
+func (q *Qbs) ToSQL(op Op, structPtr interface{}) (query string, args []interface{}, err error) {
+	defer q.Reset()
+	switch op {
+	case OpFind:
+		q.findCriteria(structPtr)
+		query, args = q.Dialect.selectSql(q.criteria)
+	case OpFindAll:
+		q.findAllCriteria(structPtr)
+		query, args = q.Dialect.selectSql(q.criteria)
+	case OpSave:
+		model := structPtrToModel(structPtr, true, q.criteria.omitFields)
+		if model.pk == nil {
+			return "", nil, errors.New("no primary key field")
+		}
+		q.criteria.model = model
+		now := time.Now()
+		if updateModelField := model.timeField("updated"); updateModelField != nil {
+			updateModelField.value = now
+		}
+		if model.pkZero() {
+			if createdModelField := model.timeField("created"); createdModelField != nil {
+				createdModelField.value = now
+			}
+			query, args = q.Dialect.insertSql(q.criteria)
+		} else {
+			q.criteria.condition = NewEqualCondition(model.pk.name, model.pk.value)
+			if ok, _ := q.prepareUpdate(structPtr, model); !ok {
+				return "", nil, nil
+			}
+			query, args = q.Dialect.updateSql(q.criteria)
+		}
+	case OpUpdate, OpDelete:
+		q.criteria.model = structPtrToModel(structPtr, true, q.criteria.omitFields)
+		q.criteria.mergePkCondition(q.Dialect)
+		if q.criteria.condition == nil {
+			return "", nil, errors.New("no condition")
+		}
+		if op == OpUpdate {
+			if ok, _ := q.prepareUpdate(structPtr, q.criteria.model); !ok {
+				return "", nil, nil
+			}
+			query, args = q.Dialect.updateSql(q.criteria)
+		} else if q.criteria.model.deleted != nil {
+			query, args = q.setDeletedSql(excludeDeleted, Raw("?", time.Now()))
+		} else {
+			query, args = q.Dialect.deleteSql(q.criteria)
+		}
+	default:
+		return "", nil, fmt.Errorf("unknown op %d", op)
+	}
+	return q.Dialect.substituteMarkers(query), args, nil
+}
+
 func (q *Qbs) doQueryRow(out interface{}, query string, args ...interface{}) error {
 	defer q.Reset()
 	rowValue := reflect.ValueOf(out)
@@ -279,7 +656,7 @@ func (q *Qbs) doQueryRow(out interface{}, query string, args ...interface{}) err
 	if err != nil {
 		return q.updateTxError(err)
 	}
-	rows, err := stmt.Query(args...)
+	rows, err := stmt.QueryContext(q.Context(), args...)
 	if err != nil {
 		return q.updateTxError(err)
 	}
@@ -296,7 +673,6 @@ func (q *Qbs) doQueryRow(out interface{}, query string, args ...interface{}) err
 }
 
 func (q *Qbs) doQueryRows(out interface{}, query string, args ...interface{}) error {
-	defer q.Reset()
 	sliceValue := reflect.Indirect(reflect.ValueOf(out))
 	structType := sliceValue.Type().Elem().Elem()
 	q.log(query, args...)
@@ -304,7 +680,7 @@ func (q *Qbs) doQueryRows(out interface{}, query string, args ...interface{}) er
 	if err != nil {
 		return q.updateTxError(err)
 	}
-	rows, err := stmt.Query(args...)
+	rows, err := stmt.QueryContext(q.Context(), args...)
 	if err != nil {
 		return q.updateTxError(err)
 	}
@@ -338,12 +714,16 @@ func (q *Qbs) scanRows(rowValue reflect.Value, rows *sql.Rows) (err error) {
 		}
 		key := cols[i]
 		paths := strings.Split(key, "___")
-		if len(paths) == 2 {
-			subStruct := rowValue.Elem().FieldByName(TableNameToStructName(paths[0]))
-			if subStruct.IsNil() {
-				subStruct.Set(reflect.New(subStruct.Type().Elem()))
+		if len(paths) >= 2 {
+			// the alias of a nested reference like post___author___name
+			subStruct := rowValue
+			for _, path := range paths[:len(paths)-1] {
+				subStruct = subStruct.Elem().FieldByName(TableNameToStructName(path))
+				if subStruct.IsNil() {
+					subStruct.Set(reflect.New(subStruct.Type().Elem()))
+				}
 			}
-			subField := subStruct.Elem().FieldByName(ColumnNameToFieldName(paths[1]))
+			subField := subStruct.Elem().FieldByName(ColumnNameToFieldName(paths[len(paths)-1]))
 			if subField.IsValid() {
 				err = q.Dialect.setModelValue(value, subField)
 				if err != nil {
@@ -360,9 +740,114 @@ func (q *Qbs) scanRows(rowValue reflect.Value, rows *sql.Rows) (err error) {
 			}
 		}
 	}
+	q.snapshot(rowValue.Interface())
 	return
 }
 
+// DisableTracking stops snapshotting the structs loaded by Find, FindAll and Iterate,
+// so Update and Save write all the columns of the structs.
+func (q *Qbs) DisableTracking() *Qbs {
+	q.noTracking = true
+	q.snapshots = nil
+	return q
+}
+
+// snapshot keeps the column values of the loaded struct, so only the changed columns will be updated.
+func (q *Qbs) snapshot(structPtr interface{}) {
+	if q.noTracking {
+		return
+	}
+	if q.snapshots == nil {
+		q.snapshots = make(map[interface{}]map[string]interface{})
+	}
+	model := structPtrToModel(structPtr, false, nil)
+	values := make(map[string]interface{}, len(model.fields))
+	for _, f := range model.fields {
+		values[f.name] = f.value
+	}
+	q.keepSnapshot(structPtr)
+	q.snapshots[structPtr] = values
+}
+
+// keepSnapshot keeps the previous snapshot of the struct when it's changed in a transaction,
+// so the snapshot is restored if the transaction or savepoint is rolled back.
+func (q *Qbs) keepSnapshot(structPtr interface{}) {
+	n := len(q.snapshotUndo)
+	if n == 0 {
+		return
+	}
+	if q.snapshotUndo[n-1] == nil {
+		q.snapshotUndo[n-1] = make(map[interface{}]map[string]interface{})
+	}
+	if _, ok := q.snapshotUndo[n-1][structPtr]; !ok {
+		q.snapshotUndo[n-1][structPtr] = q.snapshots[structPtr]
+	}
+}
+
+// releaseSnapshots moves the previous snapshots kept in the released savepoint to the enclosing transaction.
+func (q *Qbs) releaseSnapshots() {
+	n := len(q.snapshotUndo)
+	if n < 2 {
+		return
+	}
+	undo := q.snapshotUndo[n-1]
+	q.snapshotUndo = q.snapshotUndo[:n-1]
+	for structPtr, values := range undo {
+		if q.snapshotUndo[n-2] == nil {
+			q.snapshotUndo[n-2] = make(map[interface{}]map[string]interface{})
+		}
+		if _, ok := q.snapshotUndo[n-2][structPtr]; !ok {
+			q.snapshotUndo[n-2][structPtr] = values
+		}
+	}
+}
+
+// restoreSnapshots restores the snapshots changed in the rolled back transaction or savepoint.
+func (q *Qbs) restoreSnapshots() {
+	n := len(q.snapshotUndo)
+	if n == 0 {
+		return
+	}
+	undo := q.snapshotUndo[n-1]
+	q.snapshotUndo = q.snapshotUndo[:n-1]
+	if q.snapshots == nil {
+		return
+	}
+	for structPtr, values := range undo {
+		if values == nil {
+			delete(q.snapshots, structPtr)
+		} else {
+			q.snapshots[structPtr] = values
+		}
+	}
+}
+
+// omitUnchanged sets the values of the fields which are not changed since the struct was loaded to nil,
+// so they are excluded from the update, it reports whether any column is left to update.
+// The snapshot is ignored if the primary key has been changed, as it's the snapshot of another row.
+func (q *Qbs) omitUnchanged(structPtr interface{}, model *model) bool {
+	values, ok := q.snapshots[structPtr]
+	if ok && model.pk != nil && !reflect.DeepEqual(values[model.pk.name], model.pk.value) {
+		ok = false
+	}
+	if ok {
+		for _, f := range model.fields {
+			if v, ok := values[f.name]; ok && !f.pk && reflect.DeepEqual(v, f.value) {
+				f.value = nil
+			}
+		}
+	}
+	columns, _ := model.columnsAndValues(true)
+	return len(columns) > 0
+}
+
+// updated refreshes the snapshot of the struct after it's updated and its saved values are set.
+func (q *Qbs) updated(structPtr interface{}) {
+	if _, ok := q.snapshots[structPtr]; ok {
+		q.snapshot(structPtr)
+	}
+}
+
 // Same as sql.Db.Exec or sql.Tx.Exec depends on if transaction has began
 func (q *Qbs) Exec(query string, args ...interface{}) (sql.Result, error) {
 	defer q.Reset()
@@ -372,7 +857,7 @@ func (q *Qbs) Exec(query string, args ...interface{}) (sql.Result, error) {
 	if err != nil {
 		return nil, q.updateTxError(err)
 	}
-	result, err := stmt.Exec(args...)
+	result, err := stmt.ExecContext(q.Context(), args...)
 	if err != nil {
 		return nil, q.updateTxError(err)
 	}
@@ -381,29 +866,29 @@ func (q *Qbs) Exec(query string, args ...interface{}) (sql.Result, error) {
 
 // Same as sql.Db.QueryRow or sql.Tx.QueryRow depends on if transaction has began
 func (q *Qbs) QueryRow(query string, args ...interface{}) *sql.Row {
-	q.log(query, args...)
 	query = q.Dialect.substituteMarkers(query)
+	q.log(query, args...)
 	stmt, err := q.prepare(query)
 	if err != nil {
 		q.updateTxError(err)
 		return nil
 	}
-	return stmt.QueryRow(args...)
+	return stmt.QueryRowContext(q.Context(), args...)
 }
 
 // Same as sql.Db.Query or sql.Tx.Query depends on if transaction has began
 func (q *Qbs) Query(query string, args ...interface{}) (rows *sql.Rows, err error) {
-	q.log(query, args...)
 	query = q.Dialect.substituteMarkers(query)
+	q.log(query, args...)
 	stmt, err := q.prepare(query)
 	if err != nil {
 		q.updateTxError(err)
 		return
 	}
-	return stmt.Query(args...)
+	return stmt.QueryContext(q.Context(), args...)
 }
 
-// Same as sql.Db.Prepare or sql.Tx.Prepare depends on if transaction has began
+// Same as sql.Db.PrepareContext or sql.Tx.PrepareContext depends on if transaction has began
 func (q *Qbs) prepare(query string) (stmt *sql.Stmt, err error) {
 	var ok bool
 	if q.tx != nil {
@@ -411,28 +896,29 @@ func (q *Qbs) prepare(query string) (stmt *sql.Stmt, err error) {
 		if ok {
 			return
 		}
-		stmt, err = q.tx.Prepare(query)
+		stmt, err = q.tx.PrepareContext(q.Context(), query)
 		if err != nil {
 			q.updateTxError(err)
 			return
 		}
 		q.txStmtMap[query] = stmt
 	} else {
-		mu.RLock()
-		stmt, ok = stmtMap[query]
-		mu.RUnlock()
+		db := q.database()
+		db.mu.RLock()
+		stmt, ok = db.stmtMap[query]
+		db.mu.RUnlock()
 		if ok {
 			return
 		}
 
-		stmt, err = db.Prepare(query + ";")
+		stmt, err = db.sqlDb.PrepareContext(q.Context(), query+";")
 		if err != nil {
 			q.updateTxError(err)
 			return
 		}
-		mu.Lock()
-		stmtMap[query] = stmt
-		mu.Unlock()
+		db.mu.Lock()
+		db.stmtMap[query] = stmt
+		db.mu.Unlock()
 	}
 	return
 }
@@ -440,8 +926,9 @@ func (q *Qbs) prepare(query string) (stmt *sql.Stmt, err error) {
 // If Id value is not provided, save will insert the record, and the Id value will
 // be filled in the struct after insertion.
 // If Id value is provided, save will do a query count first to see if the row exists, if not then insert it,
-// otherwise update it.
+// otherwise update it, the changed columns and the version are handled the same as Update.
 // If struct implements Validator interface, it will be validated first
+// The insert hooks or the update hooks are called if the struct implements them.
 func (q *Qbs) Save(structPtr interface{}) (affected int64, err error) {
 	if v, ok := structPtr.(Validator); ok {
 		err = v.Validate(q)
@@ -454,6 +941,25 @@ func (q *Qbs) Save(structPtr interface{}) (affected int64, err error) {
 		panic("no primary key field")
 	}
 	q.criteria.model = model
+	// it's an update if the id is given and the row exists, even if it's soft deleted.
+	q.criteria.deletedScope = withDeleted
+	isInsert := model.pkZero() || q.WhereEqual(model.pk.name, model.pk.value).Count(model.table) == 0
+	if isInsert {
+		err = q.beforeInsert(structPtr)
+	} else {
+		err = q.beforeUpdate(structPtr)
+	}
+	if err != nil {
+		q.Reset()
+		return
+	}
+	// the hooks may change the struct.
+	_, insertHook := structPtr.(BeforeInserter)
+	_, updateHook := structPtr.(BeforeUpdater)
+	if isInsert && insertHook || !isInsert && updateHook {
+		model = structPtrToModel(structPtr, true, q.criteria.omitFields)
+		q.criteria.model = model
+	}
 	now := time.Now()
 	var id int64 = 0
 	updateModelField := model.timeField("updated")
@@ -461,39 +967,139 @@ func (q *Qbs) Save(structPtr interface{}) (affected int64, err error) {
 		updateModelField.value = now
 	}
 	createdModelField := model.timeField("created")
-	var isInsert bool
-	if !model.pkZero() && q.WhereEqual(model.pk.name, model.pk.value).Count(model.table) > 0 { //id is given, can be an update operation.
-		affected, err = q.Dialect.update(q)
+	if !isInsert {
+		affected, err = q.updateModel(structPtr, model)
 	} else {
 		if createdModelField != nil {
 			createdModelField.value = now
 		}
 		id, err = q.Dialect.insert(q)
-		isInsert = true
 		if err == nil {
 			affected = 1
 		}
 	}
-	if err == nil {
-		structValue := reflect.Indirect(reflect.ValueOf(structPtr))
-		if _, ok := model.pk.value.(int64); ok && id != 0 {
-			idField := structValue.FieldByName(model.pk.camelName)
-			idField.SetInt(id)
-		}
-		if updateModelField != nil {
-			updateField := structValue.FieldByName(updateModelField.camelName)
-			updateField.Set(reflect.ValueOf(now))
-		}
-		if isInsert {
-			if createdModelField != nil {
-				createdField := structValue.FieldByName(createdModelField.camelName)
-				createdField.Set(reflect.ValueOf(now))
-			}
+	if err != nil {
+		return affected, q.updateTxError(err)
+	}
+	setSavedValues(structPtr, model, id, now, isInsert)
+	if !isInsert {
+		q.updated(structPtr)
+	}
+	if isInsert {
+		err = q.afterInsert(structPtr)
+	} else {
+		err = q.afterUpdate(structPtr)
+	}
+	return affected, err
+}
+
+// Insert inserts the struct without querying if the row exists first, which is useful if the primary key is provided.
+// The created and updated time fields are set, and the generated id is filled in the struct.
+// If the row violates the primary key or an unique index, a *DuplicateKeyError will be returned.
+// If struct implements Validator interface, it will be validated first, then the insert hooks are called.
+func (q *Qbs) Insert(structPtr interface{}) (affected int64, err error) {
+	if v, ok := structPtr.(Validator); ok {
+		err = v.Validate(q)
+		if err != nil {
+			return
+		}
+	}
+	if err = q.beforeInsert(structPtr); err != nil {
+		q.Reset()
+		return
+	}
+	model := structPtrToModel(structPtr, true, q.criteria.omitFields)
+	if model.pk == nil {
+		panic("no primary key field")
+	}
+	q.criteria.model = model
+	now := time.Now()
+	if updateModelField := model.timeField("updated"); updateModelField != nil {
+		updateModelField.value = now
+	}
+	if createdModelField := model.timeField("created"); createdModelField != nil {
+		createdModelField.value = now
+	}
+	id, err := q.Dialect.insert(q)
+	if err != nil {
+		if q.Dialect.isDuplicateKeyError(err) {
+			err = &DuplicateKeyError{err}
 		}
+		return 0, q.updateTxError(err)
+	}
+	setSavedValues(structPtr, model, id, now, true)
+	return 1, q.afterInsert(structPtr)
+}
+
+// setSavedValues fills the generated id, the updated time and the created time if created is true into the saved struct.
+func setSavedValues(structPtr interface{}, model *model, id int64, now time.Time, created bool) {
+	structValue := reflect.Indirect(reflect.ValueOf(structPtr))
+	if _, ok := model.pk.value.(int64); ok && id > 0 {
+		idField := structValue.FieldByName(model.pk.camelName)
+		idField.SetInt(id)
+	}
+	if updateModelField := model.timeField("updated"); updateModelField != nil {
+		updateField := structValue.FieldByName(updateModelField.camelName)
+		updateField.Set(reflect.ValueOf(now))
+	}
+	if createdModelField := model.timeField("created"); created && createdModelField != nil {
+		createdField := structValue.FieldByName(createdModelField.camelName)
+		createdField.Set(reflect.ValueOf(now))
 	}
-	return affected, q.updateTxError(err)
 }
 
+// Upsert inserts the struct, or updates the row conflicting on the columns in one atomic statement,
+// the conflict columns default to the primary key, they should have a unique index.
+// It's INSERT ... ON DUPLICATE KEY UPDATE in MySQL, which updates the row conflicting on any unique index,
+// INSERT ... ON CONFLICT DO UPDATE in PostgreSQL and SQLite, and MERGE in Oracle.
+// The created time field is set only if it's zero and is not updated, the updated time field is always set.
+// If the struct implements Validator interface, it will be validated first.
+func (q *Qbs) Upsert(structPtr interface{}, conflictColumns ...string) (err error) {
+	defer q.Reset()
+	if v, ok := structPtr.(Validator); ok {
+		err = v.Validate(q)
+		if err != nil {
+			return
+		}
+	}
+	model := structPtrToModel(structPtr, true, q.criteria.omitFields)
+	if model.pk == nil {
+		panic("no primary key field")
+	}
+	q.criteria.model = model
+	now := time.Now()
+	updateModelField := model.timeField("updated")
+	if updateModelField != nil {
+		updateModelField.value = now
+	}
+	createdModelField := model.timeField("created")
+	if createdModelField != nil && !createdModelField.value.(time.Time).IsZero() {
+		createdModelField = nil
+	}
+	if createdModelField != nil {
+		createdModelField.value = now
+	}
+	var id int64
+	if len(conflictColumns) == 0 && model.pkZero() {
+		id, err = q.Dialect.insert(q)
+	} else {
+		if len(conflictColumns) == 0 {
+			conflictColumns = []string{model.pk.name}
+		}
+		id, err = q.Dialect.upsert(q, conflictColumns)
+	}
+	if err == nil {
+		setSavedValues(structPtr, model, id, now, createdModelField != nil)
+	}
+	return q.updateTxError(err)
+}
+
+// BulkInsert inserts the structs by multi-row insert statements, consecutive structs with the same columns
+// are inserted in one statement, as many as the dialect's placeholder limit allows.
+// If the struct type implements Validator interface, each struct will be validated before insertion,
+// the insert hooks are called on each struct.
+// The generated ids are filled in the structs, they are inferred from the last insert id in MySQL and sqlite3,
+// which requires the ids generated by a statement to be consecutive.
 func (q *Qbs) BulkInsert(sliceOfStructPtr interface{}) error {
 	defer q.Reset()
 	var err error
@@ -508,37 +1114,75 @@ func (q *Qbs) BulkInsert(sliceOfStructPtr interface{}) error {
 		}()
 	}
 	sliceValue := reflect.ValueOf(sliceOfStructPtr)
+	models := make([]*model, 0, sliceValue.Len())
+	columns := make([]string, 0, sliceValue.Len())
 	for i := 0; i < sliceValue.Len(); i++ {
-		structPtr := sliceValue.Index(i)
-		structPtrInter := structPtr.Interface()
+		structPtrInter := sliceValue.Index(i).Interface()
 		if v, ok := structPtrInter.(Validator); ok {
 			err = v.Validate(q)
 			if err != nil {
 				return q.updateTxError(err)
 			}
 		}
+		if err = q.beforeInsert(structPtrInter); err != nil {
+			return err
+		}
 		model := structPtrToModel(structPtrInter, false, nil)
 		if model.pk == nil {
 			panic("no primary key field")
 		}
-		q.criteria.model = model
-		var id int64
-		id, err = q.Dialect.insert(q)
-		if err != nil {
-			return q.updateTxError(err)
+		modelColumns, _ := model.columnsAndValues(false)
+		models = append(models, model)
+		columns = append(columns, strings.Join(modelColumns, ","))
+	}
+	start, rows := 0, 0
+	for i, model := range models {
+		if i == start {
+			modelColumns, _ := model.columnsAndValues(false)
+			rows = q.Dialect.placeholderLimit()
+			if len(modelColumns) > 0 {
+				rows /= len(modelColumns)
+			}
 		}
-		if _, ok := model.pk.value.(int64); ok && id != 0 {
-			idField := structPtr.Elem().FieldByName(model.pk.camelName)
-			idField.SetInt(id)
+		if i+1 == len(models) || i+1-start >= rows || columns[i+1] != columns[start] {
+			err = q.bulkInsert(models[start:i+1], sliceValue.Slice(start, i+1))
+			if err != nil {
+				return q.updateTxError(err)
+			}
+			start = i + 1
+		}
+	}
+	for i := 0; i < sliceValue.Len(); i++ {
+		if err = q.afterInsert(sliceValue.Index(i).Interface()); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-// If the struct type implements Validator interface, values will be validated before update.
+func (q *Qbs) bulkInsert(models []*model, sliceValue reflect.Value) error {
+	ids, err := q.Dialect.bulkInsert(q, models)
+	if err != nil {
+		return err
+	}
+	for i, model := range models {
+		if pk, ok := model.pk.value.(int64); ok && pk == 0 && i < len(ids) && ids[i] != 0 {
+			idField := sliceValue.Index(i).Elem().FieldByName(model.pk.camelName)
+			idField.SetInt(ids[i])
+		}
+	}
+	return nil
+}
+
+// If the struct type implements Validator interface, values will be validated before update,
+// then the update hooks are called if the struct implements them.
 // In order to avoid inadvertently update the struct field to zero value, it is better to define a
 // temporary struct in function, only define the fields that should be updated.
 // But the temporary struct can not implement Validator interface, we have to validate values manually.
+// If the struct was loaded by Find, FindAll or Iterate of the same Qbs instance, only the changed columns
+// will be updated, and nothing will be updated if no column is changed, see DisableTracking.
+// If the struct has a field with `version` tag, the version is checked and incremented by the update,
+// ErrStaleObject will be returned if the row has been updated or deleted since the struct was loaded.
 // The update condition can be inferred by the Id value of the struct.
 // If neither Id value or condition are provided, it would cause runtime panic
 func (q *Qbs) Update(structPtr interface{}) (affected int64, err error) {
@@ -548,25 +1192,225 @@ func (q *Qbs) Update(structPtr interface{}) (affected int64, err error) {
 			return 0, err
 		}
 	}
+	if err = q.beforeUpdate(structPtr); err != nil {
+		q.Reset()
+		return 0, err
+	}
 	model := structPtrToModel(structPtr, true, q.criteria.omitFields)
 	q.criteria.model = model
 	q.criteria.mergePkCondition(q.Dialect)
 	if q.criteria.condition == nil {
 		panic("Can not update without condition")
 	}
-	return q.Dialect.update(q)
+	affected, err = q.updateModel(structPtr, model)
+	if err != nil {
+		return affected, q.updateTxError(err)
+	}
+	q.updated(structPtr)
+	return affected, q.afterUpdate(structPtr)
+}
+
+// updateModel updates the changed columns of the model with the criteria,
+// the version condition is appended and the version is incremented if the model has a version field.
+// The stale check is skipped in dry run mode, as no row is affected.
+func (q *Qbs) updateModel(structPtr interface{}, model *model) (affected int64, err error) {
+	version := model.version
+	ok, current := q.prepareUpdate(structPtr, model)
+	if !ok {
+		q.Reset()
+		return 0, nil
+	}
+	affected, err = q.Dialect.update(q)
+	if err != nil {
+		return
+	}
+	if version != nil {
+		if affected == 0 {
+			if q.dryRun {
+				return 0, nil
+			}
+			return 0, ErrStaleObject
+		}
+		reflect.Indirect(reflect.ValueOf(structPtr)).FieldByName(version.camelName).SetInt(current + 1)
+	}
+	return
+}
+
+// prepareUpdate omits the unchanged columns of the model, it reports whether any column is left to update.
+// If the model has a version field, the version condition is appended to the criteria,
+// and the version value of the model is incremented, the current version is returned.
+func (q *Qbs) prepareUpdate(structPtr interface{}, model *model) (ok bool, current int64) {
+	version := model.version
+	var versionValue reflect.Value
+	if version != nil {
+		versionValue = reflect.ValueOf(version.value)
+	}
+	if !q.omitUnchanged(structPtr, model) {
+		return false, 0
+	}
+	if version != nil {
+		current = versionValue.Int()
+		next := reflect.New(versionValue.Type()).Elem()
+		next.SetInt(current + 1)
+		version.value = next.Interface()
+		versionCondition := NewCondition(q.Dialect.quote(version.name)+" = ?", current)
+		q.criteria.condition = (&Condition{left: q.criteria.condition}).AndCondition(versionCondition)
+	}
+	return true, current
+}
+
+// UpdateExpr updates the columns to the expressions in rows meet the condition in one statement, like:
+//
+//		q.WhereEqual("id", id).UpdateExpr("post", map[string]qbs.Expr{"view_count": qbs.Raw("view_count + ?", 1)})
+//
+// The table parameter can be either a string or a struct pointer, if it's a struct pointer,
+// its Id value will be merged into the condition.
+// If neither Id value or condition are provided, it would cause runtime panic
+func (q *Qbs) UpdateExpr(table interface{}, exprs map[string]Expr) (affected int64, err error) {
+	if _, ok := table.(string); ok {
+		q.criteria.model = &model{table: tableName(table)}
+	} else {
+		q.criteria.model = structPtrToModel(table, false, nil)
+		q.criteria.mergePkCondition(q.Dialect)
+	}
+	if q.criteria.condition == nil {
+		panic("Can not update without condition")
+	}
+	sql, args := q.Dialect.updateExprSql(q.criteria, exprs)
+	result, err := q.Exec(sql, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// UpdateColumns updates the columns to the values in rows meet the condition, so only the given columns are updated.
+// The table parameter can be either a string or a struct pointer, if it's a struct pointer,
+// it will be validated first if it implements Validator interface, its Id value will be merged into the condition,
+// and its updated time column will be set to now if it's not in the values.
+// If neither Id value or condition are provided, it would cause runtime panic
+func (q *Qbs) UpdateColumns(table interface{}, values map[string]interface{}) (affected int64, err error) {
+	exprs := make(map[string]Expr, len(values)+1)
+	for column, value := range values {
+		exprs[column] = Raw("?", value)
+	}
+	if _, ok := table.(string); !ok {
+		if v, ok := table.(Validator); ok {
+			err = v.Validate(q)
+			if err != nil {
+				return 0, err
+			}
+		}
+		model := structPtrToModel(table, false, nil)
+		if updateModelField := model.timeField("updated"); updateModelField != nil {
+			if _, ok := exprs[updateModelField.name]; !ok {
+				exprs[updateModelField.name] = Raw("?", time.Now())
+			}
+		}
+	}
+	return q.UpdateExpr(table, exprs)
+}
+
+// Increment adds delta to the column in rows meet the condition atomically, it's a shortcut of UpdateExpr.
+func (q *Qbs) Increment(table interface{}, column string, delta interface{}) (affected int64, err error) {
+	return q.UpdateExpr(table, map[string]Expr{column: Raw(q.Dialect.quote(column)+" + ?", delta)})
+}
+
+// Decrement subtracts delta from the column in rows meet the condition atomically, it's a shortcut of UpdateExpr.
+func (q *Qbs) Decrement(table interface{}, column string, delta interface{}) (affected int64, err error) {
+	return q.UpdateExpr(table, map[string]Expr{column: Raw(q.Dialect.quote(column)+" - ?", delta)})
 }
 
 // The delete condition can be inferred by the Id value of the struct
 // If neither Id value or condition are provided, it would cause runtime panic
+// If the struct has a field tagged with `qbs:"deleted"`, the rows are soft deleted by setting the field to
+// the current time, the rows which are already deleted are not affected, and the field of the struct is set.
+// The delete hooks are called if the struct implements them.
 func (q *Qbs) Delete(structPtr interface{}) (affected int64, err error) {
+	if err = q.beforeDelete(structPtr); err != nil {
+		q.Reset()
+		return
+	}
+	model := q.deleteModel(structPtr)
+	if model.deleted == nil {
+		affected, err = q.Dialect.delete(q)
+	} else {
+		now := time.Now()
+		affected, err = q.setDeleted(excludeDeleted, Raw("?", now))
+		if err == nil {
+			setDeletedValue(structPtr, model, now)
+		}
+	}
+	if err != nil {
+		return
+	}
+	return affected, q.afterDelete(structPtr)
+}
+
+// HardDelete deletes the rows even if the struct has a deleted field, the condition and the hooks are the same as Delete.
+func (q *Qbs) HardDelete(structPtr interface{}) (affected int64, err error) {
+	if err = q.beforeDelete(structPtr); err != nil {
+		q.Reset()
+		return
+	}
+	q.deleteModel(structPtr)
+	affected, err = q.Dialect.delete(q)
+	if err != nil {
+		return
+	}
+	return affected, q.afterDelete(structPtr)
+}
+
+// Restore sets the deleted field of the soft deleted rows to NULL, the condition is the same as Delete.
+// The struct should have a field tagged with `qbs:"deleted"`, the field of the struct is reset.
+func (q *Qbs) Restore(structPtr interface{}) (affected int64, err error) {
+	model := q.deleteModel(structPtr)
+	if model.deleted == nil {
+		panic("no deleted field")
+	}
+	affected, err = q.setDeleted(onlyDeleted, Raw("NULL"))
+	if err == nil {
+		setDeletedValue(structPtr, model, time.Time{})
+	}
+	return
+}
+
+func (q *Qbs) deleteModel(structPtr interface{}) *model {
 	model := structPtrToModel(structPtr, true, q.criteria.omitFields)
 	q.criteria.model = model
 	q.criteria.mergePkCondition(q.Dialect)
 	if q.criteria.condition == nil {
 		panic("Can not delete without condition")
 	}
-	return q.Dialect.delete(q)
+	return model
+}
+
+// setDeleted updates the deleted column of the rows in the scope to the value.
+func (q *Qbs) setDeleted(scope int, value Expr) (int64, error) {
+	query, args := q.setDeletedSql(scope, value)
+	result, err := q.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (q *Qbs) setDeletedSql(scope int, value Expr) (string, []interface{}) {
+	q.criteria.deletedScope = scope
+	q.criteria.condition = q.criteria.scopedCondition(q.Dialect)
+	return q.Dialect.updateExprSql(q.criteria, map[string]Expr{q.criteria.model.deleted.name: value})
+}
+
+// setDeletedValue sets the deleted field of the struct, a zero time sets a pointer field to nil.
+func setDeletedValue(structPtr interface{}, model *model, t time.Time) {
+	field := reflect.ValueOf(structPtr).Elem().FieldByName(model.deleted.camelName)
+	if field.Kind() != reflect.Ptr {
+		field.Set(reflect.ValueOf(t))
+	} else if t.IsZero() {
+		field.Set(reflect.Zero(field.Type()))
+	} else {
+		field.Set(reflect.ValueOf(&t))
+	}
 }
 
 // This method can be used to validate unique column before trying to save
@@ -576,18 +1420,25 @@ func (q *Qbs) ContainsValue(table interface{}, column string, value interface{})
 	quotedTable := q.Dialect.quote(tableName(table))
 	query := fmt.Sprintf("SELECT %v FROM %v WHERE %v = ?", quotedColumn, quotedTable, quotedColumn)
 	row := q.QueryRow(query, value)
+	if row == nil {
+		return false
+	}
 	var result interface{}
 	err := row.Scan(&result)
-	q.updateTxError(err)
+	if err != sql.ErrNoRows {
+		q.updateTxError(err)
+	}
 	return err == nil
 }
 
 // If the connection pool is not full, the Db will be sent back into the pool, otherwise the Db will get closed.
 func (q *Qbs) Close() error {
-	if connectionLimit != nil {
-		<-connectionLimit
+	q.snapshots = nil
+	if q.db.connectionLimit != nil {
+		<-q.db.connectionLimit
 	}
 	if q.tx != nil {
+		q.savepoints = nil
 		return q.Rollback()
 	}
 	return nil
@@ -595,17 +1446,25 @@ func (q *Qbs) Close() error {
 
 //Query the count of rows in a table the talbe parameter can be either a string or struct pointer.
 //If condition is given, the count will be the count of rows meet that condition.
+//If a struct pointer is given, the soft deleted rows are excluded unless WithDeleted is called.
 func (q *Qbs) Count(table interface{}) int64 {
 	quotedTable := q.Dialect.quote(tableName(table))
 	query := "SELECT COUNT(*) FROM " + quotedTable
+	if _, ok := table.(string); !ok {
+		q.criteria.model = structPtrToModel(table, false, nil)
+	}
 	var row *sql.Row
-	if q.criteria.condition != nil {
-		conditionSql, args := q.criteria.condition.Merge()
+	if condition := q.criteria.scopedCondition(q.Dialect); condition != nil {
+		conditionSql, args := condition.merge(q.Dialect)
 		query += " WHERE " + conditionSql
 		row = q.QueryRow(query, args...)
 	} else {
 		row = q.QueryRow(query)
 	}
+	if row == nil {
+		// the error of the query has been recorded.
+		return 0
+	}
 	var count int64
 	err := row.Scan(&count)
 	if err == sql.ErrNoRows {
@@ -616,6 +1475,69 @@ func (q *Qbs) Count(table interface{}) int64 {
 	return count
 }
 
+// Sum sets the sum of the column in rows meet the condition to dest, dest is set to zero if there is no row.
+// dest should be a pointer of the column's type, like *int64 for an integer column, so the sum doesn't lose precision.
+// The table parameter can be either a string or a struct pointer, the struct's join fields will be joined.
+func (q *Qbs) Sum(table interface{}, column string, dest interface{}) error {
+	err := q.aggregate(table, "SUM("+q.Dialect.quote(column)+")", dest)
+	if err == sql.ErrNoRows {
+		destValue := reflect.ValueOf(dest).Elem()
+		destValue.Set(reflect.Zero(destValue.Type()))
+		return nil
+	}
+	return err
+}
+
+// Avg returns the average of the column in rows meet the condition, "sql.ErrNoRows" will be returned if there is no row.
+func (q *Qbs) Avg(table interface{}, column string) (float64, error) {
+	var avg float64
+	err := q.aggregate(table, "AVG("+q.Dialect.quote(column)+")", &avg)
+	return avg, err
+}
+
+// Min sets the minimum value of the column in rows meet the condition to dest,
+// dest should be a pointer of the column's type, "sql.ErrNoRows" will be returned if there is no row.
+func (q *Qbs) Min(table interface{}, column string, dest interface{}) error {
+	return q.aggregate(table, "MIN("+q.Dialect.quote(column)+")", dest)
+}
+
+// Max sets the maximum value of the column in rows meet the condition to dest,
+// dest should be a pointer of the column's type, "sql.ErrNoRows" will be returned if there is no row.
+func (q *Qbs) Max(table interface{}, column string, dest interface{}) error {
+	return q.aggregate(table, "MAX("+q.Dialect.quote(column)+")", dest)
+}
+
+// CountDistinct returns the count of distinct non-null values of the column in rows meet the condition.
+func (q *Qbs) CountDistinct(table interface{}, column string) (int64, error) {
+	var count int64
+	err := q.aggregate(table, "COUNT(DISTINCT "+q.Dialect.quote(column)+")", &count)
+	return count, err
+}
+
+func (q *Qbs) aggregate(table interface{}, expr string, dest interface{}) error {
+	defer q.Reset()
+	if t, ok := table.(string); ok {
+		q.criteria.model = &model{table: t}
+	} else {
+		q.criteria.model = q.queryModel(table)
+	}
+	query, args := q.Dialect.aggregateSql(q.criteria, expr)
+	q.log(query, args...)
+	stmt, err := q.prepare(query)
+	if err != nil {
+		return q.updateTxError(err)
+	}
+	var value interface{}
+	err = stmt.QueryRowContext(q.Context(), args...).Scan(&value)
+	if err != nil {
+		return q.updateTxError(err)
+	}
+	if value == nil {
+		return sql.ErrNoRows
+	}
+	return q.Dialect.setModelValue(reflect.ValueOf(&value).Elem(), reflect.ValueOf(dest).Elem())
+}
+
 //Query raw sql and return a map.
 func (q *Qbs) QueryMap(query string, args ...interface{}) (map[string]interface{}, error) {
 	mapSlice, err := q.doQueryMap(query, true, args...)
@@ -633,11 +1555,12 @@ func (q *Qbs) QueryMapSlice(query string, args ...interface{}) ([]map[string]int
 
 func (q *Qbs) doQueryMap(query string, once bool, args ...interface{}) ([]map[string]interface{}, error) {
 	query = q.Dialect.substituteMarkers(query)
+	q.log(query, args...)
 	stmt, err := q.prepare(query)
 	if err != nil {
 		return nil, q.updateTxError(err)
 	}
-	rows, err := stmt.Query(args...)
+	rows, err := stmt.QueryContext(q.Context(), args...)
 	if err != nil {
 		return nil, q.updateTxError(err)
 	}
@@ -678,11 +1601,12 @@ func (q *Qbs) doQueryMap(query string, once bool, args ...interface{}) ([]map[st
 //This method do not support pointer field in the struct.
 func (q *Qbs) QueryStruct(dest interface{}, query string, args ...interface{}) error {
 	query = q.Dialect.substituteMarkers(query)
+	q.log(query, args...)
 	stmt, err := q.prepare(query)
 	if err != nil {
 		return q.updateTxError(err)
 	}
-	rows, err := stmt.Query(args...)
+	rows, err := stmt.QueryContext(q.Context(), args...)
 	if err != nil {
 		return q.updateTxError(err)
 	}
@@ -741,8 +1665,9 @@ func (q *Qbs) QueryStruct(dest interface{}, query string, args ...interface{}) e
 //Iterate the rows, the first parameter is a struct pointer, the second parameter is a fucntion
 //which will get called on each row, the in `do` function the structPtr's value will be set to the current row's value..
 //if `do` function returns an error, the iteration will be stopped.
+//If the struct implements AfterFinder, AfterFind is called on each row before `do`.
 func (q *Qbs) Iterate(structPtr interface{}, do func() error) error {
-	q.criteria.model = structPtrToModel(structPtr, !q.criteria.omitJoin, q.criteria.omitFields)
+	q.criteria.model = q.queryModel(structPtr)
 	query, args := q.Dialect.querySql(q.criteria)
 	q.log(query, args...)
 	defer q.Reset()
@@ -750,7 +1675,7 @@ func (q *Qbs) Iterate(structPtr interface{}, do func() error) error {
 	if err != nil {
 		return q.updateTxError(err)
 	}
-	rows, err := stmt.Query(args...)
+	rows, err := stmt.QueryContext(q.Context(), args...)
 	if err != nil {
 		return q.updateTxError(err)
 	}
@@ -761,6 +1686,9 @@ func (q *Qbs) Iterate(structPtr interface{}, do func() error) error {
 		if err != nil {
 			return err
 		}
+		if err = q.afterFind(structPtr); err != nil {
+			return err
+		}
 		if err = do(); err != nil {
 			return err
 		}
@@ -768,9 +1696,21 @@ func (q *Qbs) Iterate(structPtr interface{}, do func() error) error {
 	return nil
 }
 
+// database returns the DB which performs the statements, it doesn't perform any statement in dry run mode.
+func (q *Qbs) database() *DB {
+	if q.dryRun && !q.db.dryRun {
+		return dryRunSink()
+	}
+	return q.db
+}
+
+// log prints out the statement if Log is true, and records it in dry run mode.
 func (q *Qbs) log(query string, args ...interface{}) {
-	if q.Log && queryLogger != nil {
-		queryLogger.Print(query)
-		queryLogger.Println(args...)
+	if q.dryRun {
+		q.statements = append(q.statements, Statement{query, args})
+	}
+	if q.Log && q.db.queryLogger != nil {
+		q.db.queryLogger.Print(query)
+		q.db.queryLogger.Println(args...)
 	}
 }
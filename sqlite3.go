@@ -3,6 +3,7 @@ package qbs
 import (
 	"database/sql"
 	"reflect"
+	"strings"
 	"time"
 	"unsafe"
 )
@@ -99,7 +100,11 @@ func (d sqlite3) setModelValue(value reflect.Value, field reflect.Value) error {
 			field.SetBool(true)
 		}
 	case reflect.Float32, reflect.Float64:
-		field.SetFloat(value.Elem().Float())
+		f, err := d.parseFloat(value.Elem())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
 	case reflect.String:
 		if value.Elem().Kind() == reflect.Slice {
 			field.SetString(string(value.Elem().Bytes()))
@@ -111,7 +116,7 @@ func (d sqlite3) setModelValue(value reflect.Value, field reflect.Value) error {
 			field.SetBytes(value.Elem().Bytes())
 		}
 	case reflect.Ptr:
-		d.setPtrValue(value, field)
+		return d.setPtrValue(value, field)
 	case reflect.Struct:
 		switch field.Interface().(type) {
 		case time.Time:
@@ -209,3 +214,54 @@ func (d sqlite3) primaryKeySql(isString bool, size int) string {
 	}
 	return "integer PRIMARY KEY AUTOINCREMENT NOT NULL"
 }
+
+// SQLITE_BUSY and SQLITE_LOCKED errors.
+func (d sqlite3) isRetryableError(err error) bool {
+	errString := err.Error()
+	return strings.Contains(errString, "database is locked") || strings.Contains(errString, "database table is locked")
+}
+
+// The message is "column is not unique" or "PRIMARY KEY must be unique" before SQLite 3.8.2.
+func (d sqlite3) isDuplicateKeyError(err error) bool {
+	errString := err.Error()
+	return strings.Contains(errString, "UNIQUE constraint failed") || strings.Contains(errString, "PRIMARY KEY must be unique") ||
+		strings.Contains(errString, "not unique")
+}
+
+// SQLite transactions are always serializable.
+func (d sqlite3) txOptions(opts sql.TxOptions) sql.TxOptions {
+	opts.Isolation = sql.LevelDefault
+	return opts
+}
+
+// SQLITE_MAX_VARIABLE_NUMBER defaults to 999 before SQLite 3.32.0.
+func (d sqlite3) placeholderLimit() int {
+	return 999
+}
+
+// The last insert id is the id of the last row inserted by a multi-row insert statement in sqlite3.
+func (d sqlite3) bulkInsert(q *Qbs, models []*model) ([]int64, error) {
+	sql, args := d.dialect.bulkInsertSql(models)
+	result, err := q.Exec(sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return consecutiveIds(id-int64(len(models)-1), len(models)), nil
+}
+
+// The last insert id is not changed if the row is updated, so the primary key is returned by the statement,
+// which requires SQLite 3.35.0 or later.
+func (d sqlite3) upsert(q *Qbs, conflictColumns []string) (int64, error) {
+	sql, args := d.dialect.upsertSql(q.criteria, conflictColumns)
+	return returningId(q, sql, args)
+}
+
+func (d sqlite3) upsertSql(criteria *criteria, conflictColumns []string) (string, []interface{}) {
+	sql, values := d.base.upsertSql(criteria, conflictColumns)
+	sql += " RETURNING " + d.dialect.quote(criteria.model.pk.name)
+	return sql, values
+}
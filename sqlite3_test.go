@@ -1,6 +1,8 @@
 package qbs
 
 import (
+	"database/sql"
+	"errors"
 	"testing"
 	//"time"
 
@@ -20,6 +22,13 @@ var sqlite3Syntax = dialectSyntax{
 	"ALTER TABLE `a` ADD COLUMN `newc` text",
 	"CREATE UNIQUE INDEX `iname` ON `itable` (`a`, `b`, `c`)",
 	"CREATE INDEX `iname2` ON `itable2` (`d`, `e`)",
+	"SELECT `grade`, COUNT(*) AS `total` FROM `student` WHERE score >= ? GROUP BY `grade` HAVING COUNT(*) > ? ORDER BY `grade`",
+	"SELECT DISTINCT `post`.`author_id`, `author`.`name` AS author___name FROM `post` LEFT JOIN `user` AS `author` ON `post`.`author_id` = `author`.`id`",
+	"SELECT `name`, `grade`, `score` FROM `student` WHERE ((`grade` BETWEEN ? AND ?) AND (NOT (`score` NOT IN (?, ?)))) OR (LOWER(`name`) LIKE LOWER(?))",
+	"SELECT `name`, `grade`, `score` FROM `student` WHERE ((`grade` = ?) AND (`name` IN (SELECT `name` FROM `student` WHERE `score` > ? LIMIT ?))) OR (`score` > (SELECT `name` FROM `student` WHERE `score` > ? LIMIT ?)) LIMIT ?",
+	"INSERT INTO `bulk_model` (`name`) VALUES (?), (?)",
+	"INSERT INTO `upsert_model` (`code`, `amount`, `created`) VALUES (?, ?, ?) ON CONFLICT (`code`) DO UPDATE SET `amount` = EXCLUDED.`amount` RETURNING `id`",
+	"UPDATE `counter` SET `clicks` = `clicks` - ?, `updated` = CURRENT_TIMESTAMP, `views` = `views` + ? WHERE `id` = ?",
 }
 
 func registerSqlite3Test() {
@@ -134,6 +143,21 @@ func TestSqlite3Transaction(t *testing.T) {
 	doTestTransaction(NewAssert(t))
 }
 
+func TestSqlite3NestedTransaction(t *testing.T) {
+	registerSqlite3Test()
+	doTestNestedTransaction(NewAssert(t))
+}
+
+func TestSqlite3WithTransaction(t *testing.T) {
+	registerSqlite3Test()
+	doTestWithTransaction(NewAssert(t), errors.New("database is locked"))
+}
+
+func TestSqlite3BeginWith(t *testing.T) {
+	registerSqlite3Test()
+	doTestBeginWith(NewAssert(t))
+}
+
 func TestSqlite3SaveAndDelete(t *testing.T) {
 	mg, q := setupSqlite3Db()
 	doTestSaveAndDelete(NewAssert(t), mg, q)
@@ -164,6 +188,56 @@ func TestSqlite3Update(t *testing.T) {
 	doTestUpdate(NewAssert(t), mg, q)
 }
 
+func TestSqlite3UpdateExpr(t *testing.T) {
+	registerSqlite3Test()
+	doTestUpdateExpr(NewAssert(t))
+}
+
+func TestSqlite3UpdateColumns(t *testing.T) {
+	registerSqlite3Test()
+	doTestUpdateColumns(NewAssert(t))
+}
+
+func TestSqlite3DirtyTracking(t *testing.T) {
+	registerSqlite3Test()
+	doTestDirtyTracking(NewAssert(t))
+}
+
+func TestSqlite3OptimisticLocking(t *testing.T) {
+	registerSqlite3Test()
+	doTestOptimisticLocking(NewAssert(t))
+}
+
+func TestSqlite3SoftDelete(t *testing.T) {
+	registerSqlite3Test()
+	doTestSoftDelete(NewAssert(t))
+}
+
+func TestSqlite3Hooks(t *testing.T) {
+	registerSqlite3Test()
+	doTestHooks(NewAssert(t))
+}
+
+func TestSqlite3Preload(t *testing.T) {
+	registerSqlite3Test()
+	doTestPreload(NewAssert(t))
+}
+
+func TestSqlite3ManyToMany(t *testing.T) {
+	registerSqlite3Test()
+	doTestManyToMany(NewAssert(t))
+}
+
+func TestSqlite3NestedJoin(t *testing.T) {
+	registerSqlite3Test()
+	doTestNestedJoin(NewAssert(t))
+}
+
+func TestSqlite3MultipleRefs(t *testing.T) {
+	registerSqlite3Test()
+	doTestMultipleRefs(NewAssert(t))
+}
+
 func TestSqlite3Validation(t *testing.T) {
 	mg, q := setupSqlite3Db()
 	doTestValidation(NewAssert(t), mg, q)
@@ -184,6 +258,36 @@ func TestSqlite3Count(t *testing.T) {
 	doTestCount(NewAssert(t))
 }
 
+func TestSqlite3GroupBy(t *testing.T) {
+	registerSqlite3Test()
+	doTestGroupBy(NewAssert(t))
+}
+
+func TestSqlite3Aggregate(t *testing.T) {
+	registerSqlite3Test()
+	doTestAggregate(NewAssert(t))
+}
+
+func TestSqlite3Select(t *testing.T) {
+	registerSqlite3Test()
+	doTestSelect(NewAssert(t))
+}
+
+func TestSqlite3TypedCondition(t *testing.T) {
+	registerSqlite3Test()
+	doTestTypedCondition(NewAssert(t))
+}
+
+func TestSqlite3WhereIn(t *testing.T) {
+	registerSqlite3Test()
+	doTestWhereIn(NewAssert(t))
+}
+
+func TestSqlite3Subquery(t *testing.T) {
+	registerSqlite3Test()
+	doTestSubquery(NewAssert(t))
+}
+
 func TestSqlite3QueryMap(t *testing.T) {
 	mg, q := setupSqlite3Db()
 	doTestQueryMap(NewAssert(t), mg, q)
@@ -194,6 +298,21 @@ func TestSqlite3BulkInsert(t *testing.T) {
 	doTestBulkInsert(NewAssert(t))
 }
 
+func TestSqlite3BulkInsertBatches(t *testing.T) {
+	registerSqlite3Test()
+	doTestBulkInsertBatches(NewAssert(t))
+}
+
+func TestSqlite3Upsert(t *testing.T) {
+	registerSqlite3Test()
+	doTestUpsert(NewAssert(t))
+}
+
+func TestSqlite3Insert(t *testing.T) {
+	registerSqlite3Test()
+	doTestInsert(NewAssert(t))
+}
+
 func TestSqlite3QueryStruct(t *testing.T) {
 	registerSqlite3Test()
 	doTestQueryStruct(NewAssert(t))
@@ -222,6 +341,29 @@ func TestSqlite3Iterate(t *testing.T) {
 	doTestIterate(NewAssert(t))
 }
 
+func TestSqlite3MultipleDB(t *testing.T) {
+	registerSqlite3Test()
+	other, err := Open("sqlite3", "/tmp/bar.db", "", NewSqlite3())
+	if err != nil {
+		t.Fatal(err)
+	}
+	doTestMultipleDB(NewAssert(t), other)
+}
+
+func TestSqlite3NewDB(t *testing.T) {
+	registerSqlite3Test()
+	database, err := sql.Open("sqlite3", "/tmp/bar.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doTestNewDB(NewAssert(t), NewDB("sqlite3", database, NewSqlite3()))
+}
+
+func TestSqlite3Context(t *testing.T) {
+	registerSqlite3Test()
+	doTestContext(NewAssert(t))
+}
+
 func TestSqlite3AddColumnSQL(t *testing.T) {
 	doTestAddColumSQL(NewAssert(t), sqlite3Syntax)
 }
@@ -238,10 +380,22 @@ func TestSqlite3InsertSQL(t *testing.T) {
 	doTestInsertSQL(NewAssert(t), sqlite3Syntax)
 }
 
+func TestSqlite3BulkInsertSQL(t *testing.T) {
+	doTestBulkInsertSQL(NewAssert(t), sqlite3Syntax)
+}
+
+func TestSqlite3UpsertSQL(t *testing.T) {
+	doTestUpsertSQL(NewAssert(t), sqlite3Syntax)
+}
+
 func TestSqlite3UpdateSQL(t *testing.T) {
 	doTestUpdateSQL(NewAssert(t), sqlite3Syntax)
 }
 
+func TestSqlite3UpdateExprSQL(t *testing.T) {
+	doTestUpdateExprSQL(NewAssert(t), sqlite3Syntax)
+}
+
 func TestSqlite3DeleteSQL(t *testing.T) {
 	doTestDeleteSQL(NewAssert(t), sqlite3Syntax)
 }
@@ -254,6 +408,22 @@ func TestSqlite3QuerySQL(t *testing.T) {
 	doTestQuerySQL(NewAssert(t), sqlite3Syntax)
 }
 
+func TestSqlite3GroupBySQL(t *testing.T) {
+	doTestGroupBySQL(NewAssert(t), sqlite3Syntax)
+}
+
+func TestSqlite3SelectDistinctSQL(t *testing.T) {
+	doTestSelectDistinctSQL(NewAssert(t), sqlite3Syntax)
+}
+
+func TestSqlite3TypedConditionSQL(t *testing.T) {
+	doTestTypedConditionSQL(NewAssert(t), sqlite3Syntax)
+}
+
+func TestSqlite3SubquerySQL(t *testing.T) {
+	doTestSubquerySQL(NewAssert(t), sqlite3Syntax)
+}
+
 func TestSqlite3DropTableSQL(t *testing.T) {
 	doTestDropTableSQL(NewAssert(t), sqlite3Syntax)
 }
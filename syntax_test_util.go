@@ -1,5 +1,10 @@
 package qbs
 
+import (
+	"strings"
+	"time"
+)
+
 type dialectSyntax struct {
 	dialect                         Dialect
 	createTableWithoutPkIfExistsSql string
@@ -13,6 +18,13 @@ type dialectSyntax struct {
 	addColumnSql                    string
 	createUniqueIndexSql            string
 	createIndexSql                  string
+	groupBySql                      string
+	selectDistinctSql               string
+	typedConditionSql               string
+	subquerySql                     string
+	bulkInsertSql                   string
+	upsertSql                       string
+	updateExprSql                   string
 }
 
 type sqlGenModel struct {
@@ -24,6 +36,15 @@ type sqlGenModel struct {
 
 var sqlGenSampleData = &sqlGenModel{3, "FirstName", "LastName", 6}
 
+type gradeCount struct {
+	Grade int
+	Total int64 `qbs:"expr:COUNT(*)"`
+}
+
+func (*gradeCount) TableName() string {
+	return "student"
+}
+
 type addColumnTestTable struct {
 	Newc string `qbs:"size:100"`
 }
@@ -72,6 +93,37 @@ func doTestInsertSQL(assert *Assert, info dialectSyntax) {
 	assert.Equal(info.insertSql, sql)
 }
 
+func doTestBulkInsertSQL(assert *Assert, info dialectSyntax) {
+	type BulkModel struct {
+		Id   int64
+		Name string
+	}
+	models := []*model{
+		structPtrToModel(&BulkModel{Name: "a"}, false, nil),
+		structPtrToModel(&BulkModel{Name: "b"}, false, nil),
+	}
+	sql, args := info.dialect.bulkInsertSql(models)
+	sql = info.dialect.substituteMarkers(sql)
+	assert.Equal(info.bulkInsertSql, sql)
+	assert.Equal("[a b]", args)
+}
+
+type upsertModel struct {
+	Id      int64
+	Code    string
+	Amount  int
+	Created time.Time
+}
+
+func doTestUpsertSQL(assert *Assert, info dialectSyntax) {
+	criteria := new(criteria)
+	criteria.model = structPtrToModel(&upsertModel{Code: "a", Amount: 2}, false, nil)
+	sql, args := info.dialect.upsertSql(criteria, []string{"code"})
+	sql = info.dialect.substituteMarkers(sql)
+	assert.Equal(info.upsertSql, sql)
+	assert.Equal(3, len(args))
+}
+
 func doTestUpdateSQL(assert *Assert, info dialectSyntax) {
 	model := structPtrToModel(sqlGenSampleData, true, nil)
 	criteria := &criteria{model: model}
@@ -81,6 +133,21 @@ func doTestUpdateSQL(assert *Assert, info dialectSyntax) {
 	assert.Equal(info.updateSql, sql)
 }
 
+func doTestUpdateExprSQL(assert *Assert, info dialectSyntax) {
+	criteria := new(criteria)
+	criteria.model = &model{table: "counter"}
+	criteria.condition = Eq("id", 3)
+	exprs := map[string]Expr{
+		"views":   Raw(info.dialect.quote("views")+" + ?", 1),
+		"clicks":  Raw(info.dialect.quote("clicks")+" - ?", 2),
+		"updated": Raw("CURRENT_TIMESTAMP"),
+	}
+	sql, args := info.dialect.updateExprSql(criteria, exprs)
+	sql = info.dialect.substituteMarkers(sql)
+	assert.Equal(info.updateExprSql, sql)
+	assert.Equal("[2 1 3]", args)
+}
+
 func doTestDeleteSQL(assert *Assert, info dialectSyntax) {
 	model := structPtrToModel(sqlGenSampleData, true, nil)
 	criteria := &criteria{model: model}
@@ -109,6 +176,29 @@ func doTestSelectionSQL(assert *Assert, info dialectSyntax) {
 	assert.Equal(info.selectionSql, sql)
 }
 
+func doTestSelectDistinctSQL(assert *Assert, info dialectSyntax) {
+	type User struct {
+		Id   int64
+		Name string
+	}
+	type Post struct {
+		Id       int64
+		AuthorId int64
+		Author   *User
+		Content  string
+	}
+	model := structPtrToModel(new(Post), true, nil)
+	criteria := new(criteria)
+	criteria.model = model
+	criteria.selectFields = []string{"AuthorId", "Author.Name"}
+	criteria.distinct = true
+	sql, _ := info.dialect.querySql(criteria)
+	assert.Equal(info.selectDistinctSql, sql)
+	criteria.selectFields = []string{"Id", "Content"}
+	sql, _ = info.dialect.querySql(criteria)
+	assert.True(!strings.Contains(sql, "JOIN"))
+}
+
 func doTestQuerySQL(assert *Assert, info dialectSyntax) {
 	type Student struct {
 		Name  string
@@ -130,7 +220,60 @@ func doTestQuerySQL(assert *Assert, info dialectSyntax) {
 	assert.Equal(info.querySql, sql)
 }
 
+func doTestTypedConditionSQL(assert *Assert, info dialectSyntax) {
+	type Student struct {
+		Name  string
+		Grade int
+		Score int
+	}
+	model := structPtrToModel(new(Student), true, nil)
+	criteria := new(criteria)
+	criteria.model = model
+	condition := Between("grade", 6, 8)
+	condition.AndCondition(Not(NotIn("score", []interface{}{1, 2})))
+	condition.OrCondition(ILike("name", "j%"))
+	criteria.condition = condition
+	sql, args := info.dialect.querySql(criteria)
+	assert.Equal(info.typedConditionSql, sql)
+	assert.Equal("[6 8 1 2 j%]", args)
+}
+
+func doTestSubquerySQL(assert *Assert, info dialectSyntax) {
+	type Student struct {
+		Name  string
+		Grade int
+		Score int
+	}
+	subCriteria := new(criteria)
+	subCriteria.model = structPtrToModel(new(Student), true, nil)
+	subCriteria.selectFields = []string{"Name"}
+	subCriteria.condition = Gt("score", 60)
+	subCriteria.limit = 5
+	query, args := info.dialect.selectSql(subCriteria)
+	sub := &Subquery{query, args}
+	criteria := new(criteria)
+	criteria.model = structPtrToModel(new(Student), true, nil)
+	criteria.condition = Eq("grade", 6).AndCondition(InSubquery("name", sub)).OrCondition(CompareSubquery("score", ">", sub))
+	criteria.limit = 10
+	sql, args := info.dialect.querySql(criteria)
+	assert.Equal(info.subquerySql, sql)
+	assert.Equal("[6 60 5 60 5 10]", args)
+}
+
 func doTestDropTableSQL(assert *Assert, info dialectSyntax) {
 	sql := info.dialect.dropTableSql("drop_table")
 	assert.Equal(info.dropTableIfExistsSql, sql)
 }
+
+func doTestGroupBySQL(assert *Assert, info dialectSyntax) {
+	model := structPtrToModel(new(gradeCount), true, nil)
+	criteria := new(criteria)
+	criteria.model = model
+	criteria.condition = NewCondition("score >= ?", 60)
+	criteria.groupBys = []string{info.dialect.quote("grade")}
+	criteria.having = NewCondition("COUNT(*) > ?", 2)
+	criteria.orderBys = []order{order{info.dialect.quote("grade"), false}}
+	sql, args := info.dialect.querySql(criteria)
+	assert.Equal(info.groupBySql, sql)
+	assert.Equal(2, len(args))
+}